@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/lint"
+	"github.com/gosom/simplemigrate/sqlfluff"
+)
+
+// registerValidation defines the flags that enable the query validators
+func (c *config) registerValidation(fs *flag.FlagSet) {
+	fs.BoolVar(&c.QueryValidation, "enable-query-validation", c.QueryValidation, "enables query validation (It's WIP - avoid USAGE)")
+	fs.BoolVar(&c.Lint, "enable-lint", c.Lint, "flags destructive and locking statements before applying them")
+}
+
+// options returns the query validators that the config enables
+func (c *config) options() ([]simplemigrate.Option, error) {
+	var opts []simplemigrate.Option
+
+	if c.QueryValidation {
+		validator, err := sqlfluff.New()
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, simplemigrate.WithQueryValidator(validator))
+	}
+
+	if c.Lint {
+		opts = append(opts, simplemigrate.WithQueryValidator(lint.New()))
+	}
+
+	return opts, nil
+}
+
+func runUp(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	var schemaDump string
+
+	cfg.registerValidation(fs)
+	fs.BoolVar(&cfg.Transaction, "transaction", cfg.Transaction, "run all migrations in a transaction")
+	fs.StringVar(&schemaDump, "schema-dump", "", "file to write the schema to after migrating")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	opts, err := cfg.options()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Transaction {
+		opts = append(opts, simplemigrate.WithInTransaction())
+	}
+
+	if schemaDump != "" {
+		opts = append(opts, simplemigrate.WithSchemaDump(schemaDump))
+	}
+
+	switch {
+	case cfg.out.json():
+		opts = append(opts,
+			simplemigrate.WithPrinter(func(string, ...any) {}),
+			simplemigrate.WithProgress(cfg.out.progress),
+		)
+	case isTerminal(os.Stdout):
+		opts = append(opts, simplemigrate.WithProgress(renderProgress))
+	}
+
+	migrator, closeDB, err := newMigrator(ctx, cfg, opts...)
+	if err != nil {
+		return err
+	}
+
+	defer closeDB()
+
+	err = migrator.Migrate(ctx)
+
+	cfg.out.countApplied(cfg.Transaction, err)
+
+	return err
+}
+
+func runStatus(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	migrator, closeDB, err := newMigrator(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	defer closeDB()
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	doc := statusDocument{Migrations: []statusItem{}, Total: len(statuses)}
+
+	for _, s := range statuses {
+		if s.Pending() {
+			doc.Pending++
+		}
+
+		doc.Migrations = append(doc.Migrations, statusItem{
+			Version:   s.Version,
+			Fname:     s.Fname,
+			State:     state(s),
+			AppliedAt: s.AppliedAt,
+		})
+	}
+
+	if cfg.out.json() {
+		return cfg.out.document(doc)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "VERSION\tFILE\tSTATE\tAPPLIED AT")
+
+	for _, item := range doc.Migrations {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", item.Version, item.Fname, item.State, formatTime(item.AppliedAt))
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d migrations, %d pending\n", doc.Total, doc.Pending)
+
+	return nil
+}
+
+// state describes the state of a migration in a single word
+func state(s simplemigrate.MigrationStatus) string {
+	switch {
+	case s.Missing:
+		return "missing"
+	case s.Dirty:
+		return "dirty"
+	case s.Modified:
+		return "modified"
+	case s.Applied:
+		return "applied"
+	default:
+		return "pending"
+	}
+}
+
+func runValidate(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	cfg.registerValidation(fs)
+	fs.StringVar(&cfg.Dialect, "dialect", cfg.Dialect, "dialect passed to the validators (default the scheme of the database url or ansi)")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	dialect := cfg.Dialect
+	if dialect == "" {
+		dialect = dialectOf(cfg.DatabaseURL)
+	}
+
+	opts, err := cfg.migratorOptions()
+	if err != nil {
+		return err
+	}
+
+	validators, err := cfg.options()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := simplemigrate.New(offlineDriver{dialect: dialect}, append(opts, validators...)...).Validate(ctx)
+	if err != nil {
+		return err
+	}
+
+	cfg.out.printf("%d migrations are valid\n", len(migrations))
+
+	return nil
+}
+
+// runNew writes the next migration file to the migrations folder
+// It does not connect to the database
+func runNew(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: new needs the name of the migration", errConfig)
+	}
+
+	opts, err := cfg.migratorOptions()
+	if err != nil {
+		return err
+	}
+
+	migrator := simplemigrate.New(offlineDriver{dialect: dialectOf(cfg.DatabaseURL)}, opts...)
+
+	fname, content, err := migrator.ScaffoldMigration(ctx, strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(cfg.MigrationsFolder, fname)
+
+	//nolint:gosec // migrations are committed and read by others
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	cfg.out.printf("Created %s\n", path)
+
+	return nil
+}
+
+// runRenumber renames the migration files that collide with the files
+// applied in the database (if a database url is set) or present on a git ref
+func runRenumber(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	var (
+		ref    string
+		dryRun bool
+	)
+
+	fs.StringVar(&ref, "ref", "", "git ref whose migration files keep their versions (e.g. origin/main)")
+	fs.BoolVar(&dryRun, "dry-run", false, "print the renames without renaming the files")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if ref == "" && cfg.DatabaseURL == "" {
+		return fmt.Errorf("%w: renumber needs a database url or -ref to know which versions are taken", errConfig)
+	}
+
+	opts, err := cfg.migratorOptions()
+	if err != nil {
+		return err
+	}
+
+	var keep []string
+
+	if ref != "" {
+		files, err := gitFiles(ctx, cfg.MigrationsFolder, ref)
+		if err != nil {
+			return err
+		}
+
+		keep = append(keep, files...)
+	}
+
+	driver := simplemigrate.DBDriver(offlineDriver{dialect: dialectOf(cfg.DatabaseURL)})
+
+	// applied migrations are always kept, a renamed file would run again
+	if cfg.DatabaseURL != "" {
+		driver, err = newDBDriver(ctx, cfg.DatabaseURL)
+		if err != nil {
+			return err
+		}
+
+		defer driver.Close(ctx)
+	}
+
+	migrator := simplemigrate.New(driver, opts...)
+
+	if cfg.DatabaseURL != "" {
+		applied, err := migrator.History(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range applied {
+			keep = append(keep, m.Fname)
+		}
+	}
+
+	renames, err := migrator.Renumber(ctx, keep)
+	if err != nil {
+		return err
+	}
+
+	if len(renames) == 0 {
+		cfg.out.printf("No migrations to renumber\n")
+
+		return nil
+	}
+
+	for _, r := range renames {
+		cfg.out.printf("%s -> %s\n", r.From, r.To)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	return renameFiles(cfg.MigrationsFolder, renames)
+}
+
+// renameFiles applies the renames in two steps, so that a file can be
+// renamed to the old name of another one
+// The targets are checked before any file is renamed and the completed
+// renames are undone if one fails, so the folder is never left half renamed
+func renameFiles(folder string, renames []simplemigrate.Rename) (err error) {
+	const tempSuffix = ".renumber"
+
+	renamed := make(map[string]bool, len(renames))
+	for _, r := range renames {
+		renamed[r.From] = true
+	}
+
+	for _, r := range renames {
+		if _, err := os.Stat(filepath.Join(folder, r.From)); err != nil {
+			return err
+		}
+
+		for _, name := range []string{r.From + tempSuffix, r.To} {
+			if renamed[name] {
+				continue
+			}
+
+			if _, err := os.Stat(filepath.Join(folder, name)); err == nil {
+				return fmt.Errorf("cannot rename %s: %s exists", r.From, name)
+			}
+		}
+	}
+
+	type move struct {
+		from, to string
+	}
+
+	var done []move
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		for i := len(done) - 1; i >= 0; i-- {
+			if uerr := os.Rename(done[i].to, done[i].from); uerr != nil {
+				err = errors.Join(err, fmt.Errorf("cannot undo the rename of %s: %w", done[i].from, uerr))
+			}
+		}
+	}()
+
+	rename := func(from, to string) error {
+		from, to = filepath.Join(folder, from), filepath.Join(folder, to)
+
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+
+		done = append(done, move{from: from, to: to})
+
+		return nil
+	}
+
+	for _, r := range renames {
+		if err := rename(r.From, r.From+tempSuffix); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range renames {
+		if err := rename(r.From+tempSuffix, r.To); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// gitFiles lists the migration files in folder on the git ref
+func gitFiles(ctx context.Context, folder, ref string) ([]string, error) {
+	var stderr bytes.Buffer
+
+	//nolint:gosec // the ref is passed as a single argument to git
+	cmd := exec.CommandContext(ctx, "git", "-C", folder, "ls-tree", "--name-only", ref, "--", ".")
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-tree %s: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var files []string
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if name := path.Base(strings.TrimSpace(line)); strings.HasSuffix(name, ".sql") {
+			files = append(files, name)
+		}
+	}
+
+	return files, nil
+}
+
+func runHistory(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	migrator, closeDB, err := newMigrator(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	defer closeDB()
+
+	migrations, err := migrator.History(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cfg.out.json() {
+		doc := historyDocument{Migrations: []historyItem{}}
+
+		for _, m := range migrations {
+			doc.Migrations = append(doc.Migrations, historyItem{
+				Version:     m.Version,
+				Fname:       m.Fname,
+				Hash:        m.Hash,
+				AppliedAt:   m.AppliedAt,
+				ExecutionMS: m.ExecutionTime.Milliseconds(),
+				AppliedBy:   m.AppliedBy,
+				Hostname:    m.Hostname,
+				AppVersion:  m.AppVersion,
+				ToolVersion: m.ToolVersion,
+				Dirty:       m.Dirty,
+			})
+		}
+
+		return cfg.out.document(doc)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "VERSION\tFILE\tAPPLIED AT\tDURATION\tAPPLIED BY\tHOSTNAME\tAPP VERSION\tTOOL VERSION\tDIRTY")
+
+	for _, m := range migrations {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%t\n",
+			m.Version, m.Fname, formatTime(m.AppliedAt), m.ExecutionTime,
+			m.AppliedBy, m.Hostname, m.AppVersion, m.ToolVersion, m.Dirty)
+	}
+
+	return w.Flush()
+}
+
+func runBaseline(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	version, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%w: invalid version %q", errConfig, fs.Arg(0))
+	}
+
+	migrator, closeDB, err := newMigrator(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	defer closeDB()
+
+	if err := migrator.Baseline(ctx, version); err != nil {
+		return err
+	}
+
+	cfg.out.printf("Migrations up to %d recorded as applied\n", version)
+
+	return nil
+}
+
+func runDump(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	var path string
+
+	fs.StringVar(&path, "schema-dump", "", "file to write the schema to (default stdout)")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	migrator, closeDB, err := newMigrator(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	defer closeDB()
+
+	schema, err := migrator.DumpSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		cfg.out.printf("%s", schema.DDL())
+
+		return nil
+	}
+
+	//nolint:gosec // the schema dump is meant to be committed and read by others
+	return os.WriteFile(path, []byte(schema.DDL()), 0o644)
+}
+
+// runDrift prints the differences between the live and the expected schema
+// It returns an error if there are any
+func runDrift(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	migrator, closeDB, err := newMigrator(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	defer closeDB()
+
+	drifts, err := migrator.DetectDrift(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(drifts) == 0 {
+		cfg.out.printf("No drift detected\n")
+
+		return nil
+	}
+
+	for _, d := range drifts {
+		cfg.out.printf("%s\n", d.String())
+	}
+
+	return fmt.Errorf("%w: %d differences", simplemigrate.ErrSchemaDrift, len(drifts))
+}
+
+// runResolve clears the dirty state of a migration
+// It is resolved as "applied" if the operator completed the migration by hand
+// or "retry" if they reverted it and it should run again
+func runResolve(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	v, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%w: invalid version %q", errConfig, fs.Arg(0))
+	}
+
+	as := fs.Arg(1)
+
+	if as != "applied" && as != "retry" {
+		return fmt.Errorf("%w: resolve %d needs applied or retry, got %q", errConfig, v, as)
+	}
+
+	migrator, closeDB, err := newMigrator(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	defer closeDB()
+
+	if err := migrator.Resolve(ctx, v, as == "applied"); err != nil {
+		return err
+	}
+
+	cfg.out.printf("Migration %d resolved as %s\n", v, as)
+
+	return nil
+}
+
+func runVersion(_ context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cfg.out.printf("%s\n", simplemigrate.Version())
+
+	return nil
+}
+
+// formatTime formats t or returns "-" if it is nil
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// dialectOf returns the dialect of the driver registered for the scheme of connURL
+// without connecting to the database
+func dialectOf(connURL string) string {
+	u, err := url.Parse(connURL)
+	if err != nil || u.Scheme == "" {
+		return "ansi"
+	}
+
+	switch u.Scheme {
+	case "postgresql":
+		return "postgres"
+	case "sqlite3", "file":
+		return "sqlite"
+	default:
+		return u.Scheme
+	}
+}
+
+// errOffline is returned by the driver of commands that do not use the database
+var errOffline = errors.New("the database is not used by this command")
+
+// offlineDriver is a driver that only knows its dialect
+// It lets the validators run without a database connection
+type offlineDriver struct {
+	dialect string
+}
+
+func (d offlineDriver) Dialect() string {
+	return d.dialect
+}
+
+func (offlineDriver) Close(context.Context) error {
+	return nil
+}
+
+func (offlineDriver) CreateMigrationsTable(context.Context, string) error {
+	return errOffline
+}
+
+func (offlineDriver) SelectMigrations(context.Context, string) ([]simplemigrate.Migration, error) {
+	return nil, errOffline
+}
+
+func (offlineDriver) ApplyMigrations(context.Context, string, bool, []simplemigrate.Migration) error {
+	return errOffline
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/gosom/simplemigrate"
+)
+
+const (
+	// defaultMigrationsFolder is the migrations folder if neither a flag nor the config set it
+	defaultMigrationsFolder = "migrations"
+	// defaultMigrationsTable is the migrations table if neither a flag nor the config set it
+	defaultMigrationsTable = "schema_migrations"
+)
+
+// defaultConfigFiles are the config files that are read if -config is not given
+var defaultConfigFiles = []string{"simplemigrate.json", "simplemigrate.yaml", "simplemigrate.yml"}
+
+// envVarRe matches the ${NAME} references to environment variables in the config
+var envVarRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// config is the configuration of an environment
+// The zero values of a config file do not override the defaults
+type config struct {
+	DatabaseURL      string            `json:"database_url" yaml:"database_url"`
+	MigrationsFolder string            `json:"migrations_folder" yaml:"migrations_folder"`
+	MigrationsTable  string            `json:"migrations_table" yaml:"migrations_table"`
+	Transaction      bool              `json:"transaction" yaml:"transaction"`
+	Lint             bool              `json:"lint" yaml:"lint"`
+	QueryValidation  bool              `json:"query_validation" yaml:"query_validation"`
+	Dialect          string            `json:"dialect" yaml:"dialect"`
+	Vars             map[string]string `json:"vars" yaml:"vars"`
+	Output           string            `json:"output" yaml:"output"`
+	LockTimeout      string            `json:"lock_timeout" yaml:"lock_timeout"`
+
+	// file and env are where the config was read from
+	file string
+	env  string
+	// out writes the output of the command in the format of Output
+	out *output
+}
+
+// configFile is the content of a config file
+type configFile struct {
+	// DefaultEnv is the environment that is used if -env is not given
+	DefaultEnv   string            `json:"default_env" yaml:"default_env"`
+	Environments map[string]config `json:"environments" yaml:"environments"`
+}
+
+// loadConfig returns the defaults merged with the environment env of the
+// config file at path
+// If path is empty the first of defaultConfigFiles that exists is read,
+// and only the defaults are returned if there is none
+func loadConfig(path, env string) (config, error) {
+	cfg := config{
+		DatabaseURL:      os.Getenv("DATABASE_URL"),
+		MigrationsFolder: defaultMigrationsFolder,
+		MigrationsTable:  defaultMigrationsTable,
+		Output:           outputText,
+	}
+
+	if path == "" {
+		path = findConfigFile()
+	}
+
+	if path == "" {
+		if env != "" {
+			return cfg, fmt.Errorf("-env %s needs a config file", env)
+		}
+
+		return cfg, nil
+	}
+
+	file, err := readConfigFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if env == "" {
+		env = file.DefaultEnv
+	}
+
+	if env == "" && len(file.Environments) == 1 {
+		for name := range file.Environments {
+			env = name
+		}
+	}
+
+	selected, ok := file.Environments[env]
+
+	switch {
+	case !ok && env == "":
+		return cfg, fmt.Errorf("%s: select one of the environments with -env: %s", path, strings.Join(envNames(file), ", "))
+	case !ok:
+		return cfg, fmt.Errorf("%s: environment %s does not exist, use one of: %s", path, env, strings.Join(envNames(file), ", "))
+	}
+
+	if err := selected.interpolate(); err != nil {
+		return cfg, fmt.Errorf("%s: environment %s: %w", path, env, err)
+	}
+
+	cfg.merge(selected)
+
+	cfg.file = path
+	cfg.env = env
+
+	return cfg, nil
+}
+
+// findConfigFile returns the first of defaultConfigFiles that exists or ""
+func findConfigFile() string {
+	for _, name := range defaultConfigFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// readConfigFile decodes the json or yaml config file at path
+// Unknown fields are rejected so that typos are not silently ignored
+func readConfigFile(path string) (configFile, error) {
+	var file configFile
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return file, fmt.Errorf("config file %s does not exist", path)
+		}
+
+		return file, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+
+		err = dec.Decode(&file)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+
+		err = dec.Decode(&file)
+	default:
+		return file, fmt.Errorf("config file %s must be .json, .yaml or .yml", path)
+	}
+
+	if err != nil {
+		return file, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return file, nil
+}
+
+// envNames returns the sorted names of the environments of file
+func envNames(file configFile) []string {
+	names := make([]string, 0, len(file.Environments))
+	for name := range file.Environments {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// interpolate replaces the ${NAME} references in the string values of c
+// with environment variables
+// It is an error to reference a variable that is not set
+func (c *config) interpolate() error {
+	var missing []string
+
+	expand := func(s string) string {
+		return envVarRe.ReplaceAllStringFunc(s, func(ref string) string {
+			name := envVarRe.FindStringSubmatch(ref)[1]
+
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+
+			return value
+		})
+	}
+
+	c.DatabaseURL = expand(c.DatabaseURL)
+	c.MigrationsFolder = expand(c.MigrationsFolder)
+	c.MigrationsTable = expand(c.MigrationsTable)
+	c.Dialect = expand(c.Dialect)
+	c.LockTimeout = expand(c.LockTimeout)
+
+	for k, v := range c.Vars {
+		c.Vars[k] = expand(v)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("environment variables are not set: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// merge sets the values of c that are set in other
+func (c *config) merge(other config) {
+	if other.DatabaseURL != "" {
+		c.DatabaseURL = other.DatabaseURL
+	}
+
+	if other.MigrationsFolder != "" {
+		c.MigrationsFolder = other.MigrationsFolder
+	}
+
+	if other.MigrationsTable != "" {
+		c.MigrationsTable = other.MigrationsTable
+	}
+
+	if other.Dialect != "" {
+		c.Dialect = other.Dialect
+	}
+
+	c.Transaction = c.Transaction || other.Transaction
+	c.Lint = c.Lint || other.Lint
+	c.QueryValidation = c.QueryValidation || other.QueryValidation
+
+	if len(other.Vars) > 0 {
+		c.Vars = other.Vars
+	}
+
+	if other.Output != "" {
+		c.Output = other.Output
+	}
+
+	if other.LockTimeout != "" {
+		c.LockTimeout = other.LockTimeout
+	}
+}
+
+// override sets the value of c for the global flag name to its value in flags
+func (c *config) override(name string, flags config) {
+	switch name {
+	case "database-url":
+		c.DatabaseURL = flags.DatabaseURL
+	case "migrations-folder":
+		c.MigrationsFolder = flags.MigrationsFolder
+	case "migrations-table-name":
+		c.MigrationsTable = flags.MigrationsTable
+	case "output":
+		c.Output = flags.Output
+	case "lock-timeout":
+		c.LockTimeout = flags.LockTimeout
+	}
+}
+
+// migratorOptions returns the options for the migrations folder, the
+// migrations table, the template vars and the lock timeout
+// They are checked here because simplemigrate.New panics on invalid options
+func (c *config) migratorOptions() ([]simplemigrate.Option, error) {
+	if err := simplemigrate.ValidateTableName(c.MigrationsTable); err != nil {
+		return nil, fmt.Errorf("%w: %w", errConfig, err)
+	}
+
+	if info, err := os.Stat(c.MigrationsFolder); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s does not exist", simplemigrate.ErrMigrationFolder, c.MigrationsFolder)
+	}
+
+	opts := []simplemigrate.Option{
+		simplemigrate.WithSystemFS(c.MigrationsFolder),
+		simplemigrate.WithMigrationTable(c.MigrationsTable),
+		simplemigrate.WithTemplateVars(c.Vars),
+	}
+
+	if c.LockTimeout != "" {
+		timeout, err := time.ParseDuration(c.LockTimeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%w: lock timeout must be a positive duration, got %q", errConfig, c.LockTimeout)
+		}
+
+		opts = append(opts, simplemigrate.WithLockTimeout(timeout))
+	}
+
+	return opts, nil
+}
+
+// runConfig prints the effective configuration as json
+// The password of the database url is redacted
+func runConfig(_ context.Context, cfg config, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if u, err := url.Parse(cfg.DatabaseURL); err == nil {
+		cfg.DatabaseURL = u.Redacted()
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(struct {
+		File string `json:"file,omitempty"`
+		Env  string `json:"env,omitempty"`
+		config
+	}{File: cfg.file, Env: cfg.env, config: cfg})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig writes content to a config file called name in a temp folder
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+const jsonConfig = `{
+  "default_env": "dev",
+  "environments": {
+    "dev": {"database_url": "sqlite://dev.db", "lint": true},
+    "prod": {
+      "database_url": "postgres://migrator:${SIMPLEMIGRATE_TEST_PASSWORD}@db/app",
+      "migrations_folder": "db/migrations",
+      "migrations_table": "ops.schema_migrations",
+      "transaction": true,
+      "lock_timeout": "5m",
+      "vars": {"app_role": "${SIMPLEMIGRATE_TEST_ROLE}"}
+    }
+  }
+}`
+
+const yamlConfig = `environments:
+  staging:
+    database_url: mysql://user:pass@db/app
+    output: json
+`
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("DATABASE_URL", "sqlite://env.db")
+	t.Setenv("SIMPLEMIGRATE_TEST_PASSWORD", "secret")
+	t.Setenv("SIMPLEMIGRATE_TEST_ROLE", "app_rw")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     string
+		want    config
+		err     string
+	}{
+		{
+			name: "should use the defaults without a config file",
+			want: config{
+				DatabaseURL:      "sqlite://env.db",
+				MigrationsFolder: defaultMigrationsFolder,
+				MigrationsTable:  defaultMigrationsTable,
+				Output:           outputText,
+			},
+		},
+		{
+			name:    "should use the default environment",
+			file:    "simplemigrate.json",
+			content: jsonConfig,
+			want: config{
+				DatabaseURL:      "sqlite://dev.db",
+				MigrationsFolder: defaultMigrationsFolder,
+				MigrationsTable:  defaultMigrationsTable,
+				Lint:             true,
+				Output:           outputText,
+				env:              "dev",
+			},
+		},
+		{
+			name:    "should interpolate the selected environment",
+			file:    "simplemigrate.json",
+			content: jsonConfig,
+			env:     "prod",
+			want: config{
+				DatabaseURL:      "postgres://migrator:secret@db/app",
+				MigrationsFolder: "db/migrations",
+				MigrationsTable:  "ops.schema_migrations",
+				Transaction:      true,
+				Vars:             map[string]string{"app_role": "app_rw"},
+				Output:           outputText,
+				LockTimeout:      "5m",
+				env:              "prod",
+			},
+		},
+		{
+			name:    "should use the only environment",
+			file:    "simplemigrate.yaml",
+			content: yamlConfig,
+			want: config{
+				DatabaseURL:      "mysql://user:pass@db/app",
+				MigrationsFolder: defaultMigrationsFolder,
+				MigrationsTable:  defaultMigrationsTable,
+				Output:           outputJSON,
+				env:              "staging",
+			},
+		},
+		{
+			name:    "should reject an environment that does not exist",
+			file:    "simplemigrate.yml",
+			content: yamlConfig,
+			env:     "prod",
+			err:     "environment prod does not exist, use one of: staging",
+		},
+		{
+			name:    "should ask for an environment when there is no default",
+			file:    "simplemigrate.yaml",
+			content: "environments:\n  a: {}\n  b: {}\n",
+			err:     "select one of the environments with -env: a, b",
+		},
+		{
+			name:    "should reject unset environment variables",
+			file:    "simplemigrate.json",
+			content: `{"environments": {"dev": {"database_url": "${SIMPLEMIGRATE_TEST_UNSET}", "dialect": "${SIMPLEMIGRATE_TEST_UNSET_2}"}}}`,
+			err:     "environment variables are not set: SIMPLEMIGRATE_TEST_UNSET, SIMPLEMIGRATE_TEST_UNSET_2",
+		},
+		{
+			name:    "should reject unknown json fields",
+			file:    "simplemigrate.json",
+			content: `{"environments": {"dev": {"databse_url": "sqlite://dev.db"}}}`,
+			err:     `unknown field "databse_url"`,
+		},
+		{
+			name:    "should reject unknown yaml fields",
+			file:    "simplemigrate.yaml",
+			content: "environments:\n  dev:\n    databse_url: sqlite://dev.db\n",
+			err:     "field databse_url not found",
+		},
+		{
+			name:    "should reject other file types",
+			file:    "simplemigrate.toml",
+			content: "",
+			err:     "must be .json, .yaml or .yml",
+		},
+		{
+			name: "should reject -env without a config file",
+			env:  "prod",
+			err:  "-env prod needs a config file",
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			var path string
+
+			if tc.file != "" {
+				path = writeConfig(t, tc.file, tc.content)
+			}
+
+			got, err := loadConfig(path, tc.env)
+
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			tc.want.file = path
+			require.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("should report a missing config file", func(t *testing.T) {
+		_, err := loadConfig(filepath.Join(t.TempDir(), "simplemigrate.json"), "")
+		require.ErrorContains(t, err, "does not exist")
+	})
+}
+
+func TestConfig_Override(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		flags []string
+		want  config
+	}{
+		{
+			name: "should keep the config without flags",
+			want: config{
+				DatabaseURL:      "sqlite://dev.db",
+				MigrationsFolder: "db/migrations",
+				MigrationsTable:  "ops.schema_migrations",
+				Output:           outputJSON,
+				LockTimeout:      "5m",
+			},
+		},
+		{
+			name:  "should use the flags that are given",
+			flags: []string{"database-url", "migrations-table-name", "lock-timeout"},
+			want: config{
+				DatabaseURL:      "sqlite://flag.db",
+				MigrationsFolder: "db/migrations",
+				MigrationsTable:  "flag_migrations",
+				Output:           outputJSON,
+				LockTimeout:      "10s",
+			},
+		},
+		{
+			name:  "should use the default of a flag that is given",
+			flags: []string{"migrations-folder", "output"},
+			want: config{
+				DatabaseURL:      "sqlite://dev.db",
+				MigrationsFolder: defaultMigrationsFolder,
+				MigrationsTable:  "ops.schema_migrations",
+				Output:           outputText,
+				LockTimeout:      "5m",
+			},
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config{
+				MigrationsFolder: defaultMigrationsFolder,
+				MigrationsTable:  defaultMigrationsTable,
+				Output:           outputText,
+			}
+
+			cfg.merge(config{
+				DatabaseURL:      "sqlite://dev.db",
+				MigrationsFolder: "db/migrations",
+				MigrationsTable:  "ops.schema_migrations",
+				Output:           outputJSON,
+				LockTimeout:      "5m",
+			})
+
+			flags := config{
+				DatabaseURL:      "sqlite://flag.db",
+				MigrationsFolder: defaultMigrationsFolder,
+				MigrationsTable:  "flag_migrations",
+				Output:           outputText,
+				LockTimeout:      "10s",
+			}
+
+			for _, name := range tc.flags {
+				cfg.override(name, flags)
+			}
+
+			require.Equal(t, tc.want, cfg)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/gosom/simplemigrate"
+)
+
+// The exit codes of the cli
+// They are documented in the README and must not change
+const (
+	exitOK         = 0
+	exitError      = 1
+	exitConfig     = 2
+	exitValidation = 3
+	exitOutOfSync  = 4
+	exitLocked     = 5
+	exitMigration  = 6
+)
+
+var (
+	// errConfig is returned for invalid flags, arguments or configuration
+	errConfig = errors.New("invalid configuration")
+	// errFlags is returned when the flags cannot be parsed
+	// The flag package has already printed the error and the usage
+	errFlags = errors.New("invalid flags")
+)
+
+// exitErrors maps errors to the codes of the json output and the exit codes
+// The first match wins, errors that match none have the code "error"
+// and exit with exitError
+var exitErrors = []struct {
+	err  error
+	code string
+	exit int
+}{
+	// a canceled command often fails with the error of the driver too
+	{context.Canceled, "canceled", exitError},
+	{errFlags, "invalid_configuration", exitConfig},
+	{errConfig, "invalid_configuration", exitConfig},
+	{simplemigrate.ErrUnknownDriver, "unknown_driver", exitConfig},
+	{simplemigrate.ErrInvalidIdentifier, "invalid_identifier", exitConfig},
+	{simplemigrate.ErrTransactionalDDLUnsupported, "transactional_ddl_unsupported", exitConfig},
+	{simplemigrate.ErrOutOfSync, "out_of_sync", exitOutOfSync},
+	{simplemigrate.ErrSchemaDrift, "schema_drift", exitOutOfSync},
+	{simplemigrate.ErrMetaVersion, "unsupported_table_layout", exitOutOfSync},
+	{simplemigrate.ErrInvalidQuery, "invalid_query", exitValidation},
+	{simplemigrate.ErrInvalidMigrationFile, "invalid_migration_file", exitValidation},
+	{simplemigrate.ErrMigrationFolder, "invalid_migration_folder", exitValidation},
+	{simplemigrate.ErrLockTimeout, "lock_timeout", exitLocked},
+	{simplemigrate.ErrDirtyMigration, "dirty_migration", exitMigration},
+	{simplemigrate.ErrMigrationFailed, "migration_failed", exitMigration},
+	{simplemigrate.ErrNotDirty, "not_dirty", exitError},
+	{simplemigrate.ErrResolveUnsupported, "resolve_unsupported", exitError},
+	{simplemigrate.ErrBaseline, "baseline", exitError},
+	{context.DeadlineExceeded, "timeout", exitError},
+}
+
+// errorCode returns the code of err in the json output
+func errorCode(err error) string {
+	for _, e := range exitErrors {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	return "error"
+}
+
+// exitCode returns the exit code for err
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	for _, e := range exitErrors {
+		if errors.Is(err, e.err) {
+			return e.exit
+		}
+	}
+
+	return exitError
+}
+
+// parseFlags parses args with fs
+// Parse errors are wrapped with errFlags, flag.ErrHelp is returned as is
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", errFlags, err)
+}
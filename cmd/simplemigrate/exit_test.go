@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err  error
+		code string
+		exit int
+	}{
+		{errFlags, "invalid_configuration", exitConfig},
+		{errConfig, "invalid_configuration", exitConfig},
+		{simplemigrate.ErrUnknownDriver, "unknown_driver", exitConfig},
+		{simplemigrate.ErrInvalidIdentifier, "invalid_identifier", exitConfig},
+		{simplemigrate.ErrTransactionalDDLUnsupported, "transactional_ddl_unsupported", exitConfig},
+		{simplemigrate.ErrOutOfSync, "out_of_sync", exitOutOfSync},
+		{simplemigrate.ErrSchemaDrift, "schema_drift", exitOutOfSync},
+		{simplemigrate.ErrMetaVersion, "unsupported_table_layout", exitOutOfSync},
+		{simplemigrate.ErrInvalidQuery, "invalid_query", exitValidation},
+		{simplemigrate.ErrInvalidMigrationFile, "invalid_migration_file", exitValidation},
+		{simplemigrate.ErrMigrationFolder, "invalid_migration_folder", exitValidation},
+		{simplemigrate.ErrLockTimeout, "lock_timeout", exitLocked},
+		{simplemigrate.ErrDirtyMigration, "dirty_migration", exitMigration},
+		{simplemigrate.ErrMigrationFailed, "migration_failed", exitMigration},
+		{simplemigrate.ErrNotDirty, "not_dirty", exitError},
+		{simplemigrate.ErrResolveUnsupported, "resolve_unsupported", exitError},
+		{simplemigrate.ErrBaseline, "baseline", exitError},
+		{context.DeadlineExceeded, "timeout", exitError},
+		{context.Canceled, "canceled", exitError},
+	}
+
+	// every documented error is covered
+	require.Len(t, tests, len(exitErrors))
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.code, func(t *testing.T) {
+			t.Parallel()
+
+			err := fmt.Errorf("wrapped: %w", tc.err)
+
+			require.Equal(t, tc.code, errorCode(err))
+			require.Equal(t, tc.exit, exitCode(err))
+		})
+	}
+
+	t.Run("should exit with 0 without an error", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, exitOK, exitCode(nil))
+	})
+
+	t.Run("should use the generic code for other errors", func(t *testing.T) {
+		t.Parallel()
+
+		require.Equal(t, "error", errorCode(errors.New("boom")))
+		require.Equal(t, exitError, exitCode(errors.New("boom")))
+	})
+
+	t.Run("should report changed files as out of sync", func(t *testing.T) {
+		t.Parallel()
+
+		err := fmt.Errorf("%w: %w", simplemigrate.ErrInvalidMigrationFile, simplemigrate.ErrOutOfSync)
+
+		require.Equal(t, "out_of_sync", errorCode(err))
+		require.Equal(t, exitOutOfSync, exitCode(err))
+	})
+
+	t.Run("should report a canceled migration as canceled", func(t *testing.T) {
+		t.Parallel()
+
+		err := fmt.Errorf("%w: %w", context.Canceled, simplemigrate.ErrMigrationFailed)
+
+		require.Equal(t, "canceled", errorCode(err))
+		require.Equal(t, exitError, exitCode(err))
+	})
+}
@@ -4,114 +4,239 @@ import (
 	"context"
 	"errors"
 	"flag"
-	"net/url"
+	"fmt"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gosom/simplemigrate"
-	"github.com/gosom/simplemigrate/postgres"
-	"github.com/gosom/simplemigrate/sqlfluff"
-	"github.com/gosom/simplemigrate/sqlite"
+
+	// drivers register themselves for the schemes of their urls
+	_ "github.com/gosom/simplemigrate/mysql"
+	_ "github.com/gosom/simplemigrate/pgx"
+	_ "github.com/gosom/simplemigrate/postgres"
+	_ "github.com/gosom/simplemigrate/sqlite"
 )
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// a canceled command releases the migration lock and exits with the canceled code
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-	if err := run(ctx); err != nil {
-		panic(err)
-	}
-}
+	err := run(ctx, os.Args[1:])
 
-func run(ctx context.Context) error {
-	args := parseArgs()
+	cancel()
 
-	driver, err := newDBDriver(ctx)
 	if err != nil {
-		return err
+		if !errors.Is(err, errFlags) {
+			fmt.Fprintf(os.Stderr, "simplemigrate: %v\n", err)
+		}
+
+		os.Exit(exitCode(err))
 	}
+}
 
-	defer driver.Close(ctx)
+// command is a subcommand of the cli
+// run defines its own flags on fs, parses args with them and runs the command
+// cfg is the merged configuration, that the flags of the command override
+type command struct {
+	name    string
+	aliases []string
+	args    string
+	summary string
+	run     func(ctx context.Context, cfg config, fs *flag.FlagSet, args []string) error
+}
 
-	opts := []simplemigrate.Option{
-		simplemigrate.WithSystemFS(args.migrationsFolder),
-		simplemigrate.WithMigrationTable(args.migrationsTableName),
+func commands() []command {
+	return []command{
+		{name: "up", aliases: []string{"migrate"}, summary: "apply the pending migrations (default)", run: runUp},
+		{name: "status", summary: "show which migrations are applied and which are pending", run: runStatus},
+		{name: "validate", summary: "check the migration files without touching the database", run: runValidate},
+		{name: "history", summary: "show the applied migrations as recorded in the migrations table", run: runHistory},
+		{name: "new", args: "<name>", summary: "create the next migration file", run: runNew},
+		{name: "renumber", summary: "move unapplied migrations that collide with applied or merged ones to the next versions", run: runRenumber},
+		{name: "baseline", args: "<version>", summary: "record the migrations up to version as applied without running them", run: runBaseline},
+		{name: "dump", summary: "write the schema of the database", run: runDump},
+		{name: "drift", summary: "compare the database with the schema the migrations produce", run: runDrift},
+		{name: "resolve", args: "<version> applied|retry", summary: "clear the dirty state of a migration", run: runResolve},
+		{name: "config", summary: "print the effective configuration", run: runConfig},
+		{name: "version", summary: "print the version of simplemigrate", run: runVersion},
 	}
+}
 
-	if args.enableQueryValidation {
-		validator, err := sqlfluff.New()
-		if err != nil {
-			return err
+func run(ctx context.Context, args []string) error {
+	var (
+		flags      config
+		configPath string
+		env        string
+	)
+
+	fs := flag.NewFlagSet("simplemigrate", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", "", "config file (default simplemigrate.json, .yaml or .yml if it exists)")
+	fs.StringVar(&env, "env", "", "environment of the config file to use")
+	fs.StringVar(&flags.DatabaseURL, "database-url", "", "database url (default $DATABASE_URL)")
+	fs.StringVar(&flags.MigrationsFolder, "migrations-folder", defaultMigrationsFolder, "migrations folder")
+	fs.StringVar(&flags.MigrationsTable, "migrations-table-name", defaultMigrationsTable, "migrations table name")
+	fs.StringVar(&flags.Output, "output", outputText, "output format: text or json")
+	fs.StringVar(&flags.LockTimeout, "lock-timeout", "", "how long to wait for the migration lock, e.g. 30s (default wait until canceled)")
+
+	fs.Usage = func() {
+		out := fs.Output()
+
+		fmt.Fprintf(out, "Usage: %s [global flags] <command> [flags]\n\nCommands:\n", fs.Name())
+
+		for _, cmd := range commands() {
+			fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.summary)
 		}
 
-		opts = append(opts, simplemigrate.WithQueryValidator(validator))
+		fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n\nGlobal flags:\n", fs.Name())
+		fs.PrintDefaults()
 	}
 
-	if args.runInTransaction {
-		opts = append(opts, simplemigrate.WithInTransaction())
+	if err := parseFlags(fs, args); err != nil {
+		return ignoreHelp(err)
 	}
 
-	migrator := simplemigrate.New(driver, opts...)
+	cfg, err := loadConfig(configPath, env)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
 
-	return migrator.Migrate(ctx)
+	// flags that are given explicitly override the config
+	fs.Visit(func(f *flag.Flag) {
+		cfg.override(f.Name, flags)
+	})
+
+	cfg.out, err = newOutput(cfg.Output, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errConfig, err)
+	}
+
+	return ignoreHelp(runCommand(ctx, cfg, fs.Args()))
 }
 
-type args struct {
-	runInTransaction      bool
-	enableQueryValidation bool
-	migrationsFolder      string
-	migrationsTableName   string
+// ignoreHelp returns nil if err is returned because the help was requested
+func ignoreHelp(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+
+	return err
 }
 
-func parseArgs() args {
-	ans := args{}
+// runCommand finds the command named by the first argument and runs it
+// with the rest of them
+func runCommand(ctx context.Context, cfg config, args []string) error {
+	name := "up"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
 
-	flag.BoolVar(&ans.runInTransaction, "transaction", false, "run all migrations in a transaction")
-	flag.BoolVar(&ans.enableQueryValidation, "enable-query-validation", false, "enables query validation (It's WIP - avoid USAGE)")
-	flag.StringVar(&ans.migrationsFolder, "migrations-folder", "migrations", "migrations folder")
-	flag.StringVar(&ans.migrationsTableName, "migrations-table-name", "schema_migrations", "migrations table name")
+	for _, cmd := range commands() {
+		if cmd.name != name && !slices.Contains(cmd.aliases, name) {
+			continue
+		}
+
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: simplemigrate [global flags] %s [flags] %s\n\n%s\n",
+				cmd.name, cmd.args, strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
 
-	flag.Parse()
+			if hasFlags(fs) {
+				fmt.Fprint(fs.Output(), "\nFlags:\n")
+				fs.PrintDefaults()
+			}
+		}
+
+		cfg.out.begin(cmd.name)
+
+		err := cmd.run(ctx, cfg, fs, args)
+
+		// drivers report an interrupted query with their own errors
+		if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+			err = fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+
+		if !errors.Is(err, flag.ErrHelp) {
+			cfg.out.end(err)
+		}
 
-	return ans
+		return err
+	}
+
+	return fmt.Errorf("%w: unknown command %q", errConfig, name)
 }
 
-func newDBDriver(ctx context.Context) (simplemigrate.DBDriver, error) {
-	connURL, ok := os.LookupEnv("DATABASE_URL")
-	if !ok {
-		return nil, errors.New("DATABASE_URL is not set")
+// newMigrator connects to the database and creates a migrator for it
+// The returned function closes the connection
+func newMigrator(ctx context.Context, cfg config, opts ...simplemigrate.Option) (*simplemigrate.Migrator, func(), error) {
+	base, err := cfg.migratorOptions()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	u, err := url.Parse(connURL)
+	driver, err := newDBDriver(ctx, cfg.DatabaseURL)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	switch u.Scheme {
-	case "sqlite":
-		conn, err := sqlite.Connect(u.Host)
-		if err != nil {
-			return nil, err
-		}
+	return simplemigrate.New(driver, append(base, opts...)...), func() { _ = driver.Close(ctx) }, nil
+}
 
-		err = conn.PingContext(ctx)
-		if err != nil {
-			return nil, err
-		}
+func newDBDriver(ctx context.Context, connURL string) (simplemigrate.DBDriver, error) {
+	if connURL == "" {
+		return nil, fmt.Errorf("%w: DATABASE_URL is not set, set it, use -database-url or a config file", errConfig)
+	}
 
-		return sqlite.New(conn), nil
-	case "postgres":
-		conn, err := postgres.Connect(connURL)
-		if err != nil {
-			return nil, err
-		}
+	return simplemigrate.Open(ctx, connURL)
+}
 
-		err = conn.PingContext(ctx)
-		if err != nil {
-			return nil, err
-		}
+// renderProgress renders the progress as a single line that is
+// rewritten in place and cleared when a migration finishes
+func renderProgress(p simplemigrate.Progress) {
+	const clearLine = "\r\033[K"
+
+	// the printer writes the result and the retries on their own line
+	if p.Done || p.Retry {
+		fmt.Print(clearLine)
+
+		return
+	}
+
+	eta := "?"
+	if p.ETA > 0 {
+		eta = p.ETA.Round(time.Second).String()
+	}
 
-		return postgres.New(conn), nil
-	default:
-		return nil, simplemigrate.ErrUnknownDriver
+	step := "starting"
+	if p.Statement > 0 {
+		step = fmt.Sprintf("statement %d/%d", p.Statement, p.Statements)
 	}
+
+	fmt.Printf("%s[%d/%d] %s %s elapsed=%s eta=%s",
+		clearLine, p.Index, p.Total, p.Fname, step,
+		p.Elapsed.Round(time.Second), eta)
+}
+
+// isTerminal returns true if f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// hasFlags returns true if any flag is defined on fs
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+
+	fs.VisitAll(func(*flag.Flag) {
+		found = true
+	})
+
+	return found
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gosom/simplemigrate"
+)
+
+const (
+	// outputText is the output for humans
+	outputText = "text"
+	// outputJSON is newline delimited json events, or a single json
+	// document for status and history
+	outputJSON = "json"
+)
+
+// The names of the events of the json output
+const (
+	eventRunStart        = "run_start"
+	eventMigrationStart  = "migration_start"
+	eventMigrationFinish = "migration_finish"
+	eventRetry           = "retry"
+	eventMessage         = "message"
+	eventError           = "error"
+	eventSummary         = "summary"
+)
+
+// event is a line of the json output
+// The field names are a contract with the scripts that parse them,
+// they are documented in the README and must not change
+type event struct {
+	Event      string        `json:"event"`
+	Time       time.Time     `json:"time"`
+	Command    string        `json:"command,omitempty"`
+	Version    int           `json:"version,omitempty"`
+	Fname      string        `json:"fname,omitempty"`
+	Index      int           `json:"index,omitempty"`
+	Total      int           `json:"total,omitempty"`
+	Attempt    int           `json:"attempt,omitempty"`
+	BackoffMS  *int64        `json:"backoff_ms,omitempty"`
+	Status     string        `json:"status,omitempty"`
+	DurationMS *int64        `json:"duration_ms,omitempty"`
+	Applied    *int          `json:"applied,omitempty"`
+	Message    string        `json:"message,omitempty"`
+	Error      *errorDetails `json:"error,omitempty"`
+}
+
+// errorDetails describes an error in the json output
+type errorDetails struct {
+	// Code identifies the kind of the error, see exitErrors
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Version and Fname are set if a migration failed
+	Version int    `json:"version,omitempty"`
+	Fname   string `json:"fname,omitempty"`
+}
+
+// output writes the output of a command as text or as json
+type output struct {
+	format  string
+	w       io.Writer
+	command string
+	start   time.Time
+	applied int
+	// finished is the number of migrations that finished without an error
+	// They are only applied once Migrate returns with -transaction
+	finished int
+	failed   *simplemigrate.Progress
+}
+
+func newOutput(format string, w io.Writer) (*output, error) {
+	if format != outputText && format != outputJSON {
+		return nil, fmt.Errorf("output must be %s or %s, got %q", outputText, outputJSON, format)
+	}
+
+	return &output{format: format, w: w}, nil
+}
+
+func (o *output) json() bool {
+	return o.format == outputJSON
+}
+
+// printf writes a message for humans
+// In json mode it is written as a message event
+func (o *output) printf(format string, args ...any) {
+	if !o.json() {
+		fmt.Fprintf(o.w, format, args...)
+
+		return
+	}
+
+	o.emit(event{Event: eventMessage, Message: strings.TrimSpace(fmt.Sprintf(format, args...))})
+}
+
+// document writes v as a single json document
+func (o *output) document(v any) error {
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+// begin writes the run_start event of command
+// Commands that write a single document do not have events
+func (o *output) begin(command string) {
+	o.command = command
+	o.start = time.Now()
+
+	if o.json() && !writesDocument(command) {
+		o.emit(event{Event: eventRunStart, Command: command})
+	}
+}
+
+// end writes the error event if err is not nil and the summary
+func (o *output) end(err error) {
+	if !o.json() {
+		return
+	}
+
+	if err != nil {
+		e := &errorDetails{Code: errorCode(err), Message: err.Error()}
+
+		if o.failed != nil {
+			e.Version = o.failed.Version
+			e.Fname = o.failed.Fname
+		}
+
+		o.emit(event{Event: eventError, Command: o.command, Error: e})
+	}
+
+	if writesDocument(o.command) {
+		return
+	}
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+
+	summary := event{
+		Event:      eventSummary,
+		Command:    o.command,
+		Status:     status,
+		DurationMS: durationMS(time.Since(o.start)),
+	}
+
+	if o.command == "up" {
+		summary.Applied = &o.applied
+	}
+
+	o.emit(summary)
+}
+
+// progress writes the migration_start, retry and migration_finish events
+// It is used as the progress reporter of the migrator
+func (o *output) progress(p simplemigrate.Progress) {
+	switch {
+	case p.Done:
+		status := "ok"
+
+		if p.Err != nil {
+			status = "failed"
+			o.failed = &p
+		} else {
+			o.failed = nil
+			o.finished++
+		}
+
+		o.emit(event{
+			Event:      eventMigrationFinish,
+			Version:    p.Version,
+			Fname:      p.Fname,
+			Index:      p.Index,
+			Total:      p.Total,
+			Attempt:    p.Attempt,
+			Status:     status,
+			DurationMS: durationMS(p.Duration),
+		})
+	case p.Retry:
+		// the migration fails with this error if the run is canceled while waiting
+		o.failed = &p
+
+		o.emit(event{
+			Event:     eventRetry,
+			Version:   p.Version,
+			Fname:     p.Fname,
+			Index:     p.Index,
+			Total:     p.Total,
+			Attempt:   p.Attempt,
+			BackoffMS: durationMS(p.Backoff),
+			Error:     &errorDetails{Code: errorCode(p.Err), Message: p.Err.Error()},
+		})
+	case p.Statement == 0 && p.Attempt == 1:
+		// the next attempts of a retried migration follow its retry events
+		o.emit(event{Event: eventMigrationStart, Version: p.Version, Fname: p.Fname, Index: p.Index, Total: p.Total})
+	}
+}
+
+// countApplied sets the applied migrations of the summary once Migrate
+// has returned err
+// With -transaction the migrations are only applied if all of them are
+func (o *output) countApplied(transaction bool, err error) {
+	if err == nil || !transaction {
+		o.applied = o.finished
+	}
+}
+
+func (o *output) emit(e event) {
+	e.Time = time.Now().UTC()
+
+	// the events only contain types that can be marshaled
+	data, _ := json.Marshal(e)
+
+	fmt.Fprintf(o.w, "%s\n", data)
+}
+
+// writesDocument returns true if command writes a single json document
+func writesDocument(command string) bool {
+	return command == "status" || command == "history" || command == "config"
+}
+
+func durationMS(d time.Duration) *int64 {
+	ms := d.Milliseconds()
+
+	return &ms
+}
+
+// statusDocument is the json output of the status command
+type statusDocument struct {
+	Migrations []statusItem `json:"migrations"`
+	Total      int          `json:"total"`
+	Pending    int          `json:"pending"`
+}
+
+type statusItem struct {
+	Version   int        `json:"version"`
+	Fname     string     `json:"fname"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// historyDocument is the json output of the history command
+type historyDocument struct {
+	Migrations []historyItem `json:"migrations"`
+}
+
+type historyItem struct {
+	Version     int        `json:"version"`
+	Fname       string     `json:"fname"`
+	Hash        string     `json:"hash"`
+	AppliedAt   *time.Time `json:"applied_at"`
+	ExecutionMS int64      `json:"execution_ms"`
+	AppliedBy   string     `json:"applied_by"`
+	Hostname    string     `json:"hostname"`
+	AppVersion  string     `json:"app_version"`
+	ToolVersion string     `json:"tool_version"`
+	Dirty       bool       `json:"dirty"`
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+)
+
+var (
+	timeRe     = regexp.MustCompile(`"time":"[^"]+"`)
+	durationRe = regexp.MustCompile(`"duration_ms":\d+`)
+)
+
+// lines returns the json events written to out with the times replaced by T
+// The duration of the summary depends on the test run, so it is replaced by 0
+func lines(out string) []string {
+	ans := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+
+	for i := range ans {
+		ans[i] = timeRe.ReplaceAllString(ans[i], `"time":"T"`)
+
+		if strings.HasPrefix(ans[i], `{"event":"summary"`) {
+			ans[i] = durationRe.ReplaceAllString(ans[i], `"duration_ms":0`)
+		}
+	}
+
+	return ans
+}
+
+// migrate reports the progress of a run that applies 1_a.sql after a retry
+// and then applies 2_b.sql or fails on it
+func migrate(o *output, fail bool) error {
+	a := simplemigrate.Progress{Index: 1, Total: 2, Version: 1, Fname: "1_a.sql", Statements: 1, Attempt: 1}
+
+	o.progress(a)
+
+	a.Statement = 1
+	o.progress(a)
+
+	a.Statement, a.Retry, a.Backoff, a.Err = 0, true, 10*time.Millisecond, errors.New("database is locked")
+	o.progress(a)
+
+	a.Attempt, a.Retry, a.Backoff, a.Err = 2, false, 0, nil
+	o.progress(a)
+
+	a.Done, a.Duration = true, 5*time.Millisecond
+	o.progress(a)
+
+	b := simplemigrate.Progress{Index: 2, Total: 2, Version: 2, Fname: "2_b.sql", Statements: 1, Attempt: 1}
+	o.progress(b)
+
+	b.Done, b.Duration = true, 7*time.Millisecond
+
+	if !fail {
+		o.progress(b)
+
+		return nil
+	}
+
+	b.Err = errors.New("boom")
+	o.progress(b)
+
+	return fmt.Errorf("%w: %w", simplemigrate.ErrMigrationFailed, b.Err)
+}
+
+func TestOutput_JSON(t *testing.T) {
+	t.Parallel()
+
+	var out strings.Builder
+
+	o, err := newOutput(outputJSON, &out)
+	require.NoError(t, err)
+
+	o.begin("up")
+
+	err = migrate(o, true)
+
+	o.countApplied(false, err)
+	o.end(err)
+
+	require.Equal(t, []string{
+		`{"event":"run_start","time":"T","command":"up"}`,
+		`{"event":"migration_start","time":"T","version":1,"fname":"1_a.sql","index":1,"total":2}`,
+		`{"event":"retry","time":"T","version":1,"fname":"1_a.sql","index":1,"total":2,"attempt":1,"backoff_ms":10,` +
+			`"error":{"code":"error","message":"database is locked"}}`,
+		`{"event":"migration_finish","time":"T","version":1,"fname":"1_a.sql","index":1,"total":2,"attempt":2,` +
+			`"status":"ok","duration_ms":5}`,
+		`{"event":"migration_start","time":"T","version":2,"fname":"2_b.sql","index":2,"total":2}`,
+		`{"event":"migration_finish","time":"T","version":2,"fname":"2_b.sql","index":2,"total":2,"attempt":1,` +
+			`"status":"failed","duration_ms":7}`,
+		`{"event":"error","time":"T","command":"up","error":{"code":"migration_failed","message":"migration failed: boom",` +
+			`"version":2,"fname":"2_b.sql"}}`,
+		`{"event":"summary","time":"T","command":"up","status":"error","duration_ms":0,"applied":1}`,
+	}, lines(out.String()))
+}
+
+func TestOutput_Applied(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		transaction bool
+		fail        bool
+		summary     string
+	}{
+		{
+			name:    "should count the applied migrations",
+			summary: `{"event":"summary","time":"T","command":"up","status":"ok","duration_ms":0,"applied":2}`,
+		},
+		{
+			name:    "should count the migrations applied before the failure",
+			fail:    true,
+			summary: `{"event":"summary","time":"T","command":"up","status":"error","duration_ms":0,"applied":1}`,
+		},
+		{
+			name:        "should count the migrations applied in a transaction",
+			transaction: true,
+			summary:     `{"event":"summary","time":"T","command":"up","status":"ok","duration_ms":0,"applied":2}`,
+		},
+		{
+			name:        "should not count the migrations of a transaction that failed",
+			transaction: true,
+			fail:        true,
+			summary:     `{"event":"summary","time":"T","command":"up","status":"error","duration_ms":0,"applied":0}`,
+		},
+	}
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var out strings.Builder
+
+			o, err := newOutput(outputJSON, &out)
+			require.NoError(t, err)
+
+			o.begin("up")
+
+			err = migrate(o, tc.fail)
+
+			o.countApplied(tc.transaction, err)
+			o.end(err)
+
+			got := lines(out.String())
+			require.Equal(t, tc.summary, got[len(got)-1])
+		})
+	}
+}
+
+func TestOutput_Text(t *testing.T) {
+	t.Parallel()
+
+	var out strings.Builder
+
+	o, err := newOutput(outputText, &out)
+	require.NoError(t, err)
+
+	o.begin("up")
+	o.printf("%s...OK\n", "1_a.sql")
+	o.end(errors.New("boom"))
+
+	require.Equal(t, "1_a.sql...OK\n", out.String())
+}
@@ -0,0 +1,63 @@
+package simplemigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDirtyMigration is returned by Migrate when a migration failed partway
+	// and may have left changes behind
+	ErrDirtyMigration = errors.New("dirty migration")
+	// ErrNotDirty is returned when resolving a migration that is not dirty
+	ErrNotDirty = errors.New("migration is not dirty")
+	// ErrResolveUnsupported is returned when the driver cannot resolve dirty migrations
+	ErrResolveUnsupported = errors.New("driver does not support resolving dirty migrations")
+)
+
+// Resolver is an optional interface implemented by drivers that record
+// migrations as dirty while they run outside of a transaction
+type Resolver interface {
+	// ResolveMigration clears the dirty state of the migration with version
+	// If applied is true the migration is marked as applied,
+	// otherwise its row is deleted so that it runs again
+	// It returns ErrNotDirty if the migration is not dirty
+	ResolveMigration(ctx context.Context, migrationsTable string, version int, applied bool) error
+}
+
+// checkDirty returns ErrDirtyMigration if any of the applied migrations is dirty
+func checkDirty(applied []Migration) error {
+	for _, m := range applied {
+		if m.Dirty {
+			return fmt.Errorf("%w: %s (version %d) failed partway, "+
+				"fix the database by hand and resolve it as applied or to be retried", ErrDirtyMigration, m.Fname, m.Version)
+		}
+	}
+
+	return nil
+}
+
+// Resolve clears the dirty state of the migration with version after an
+// operator fixed the database by hand
+// If applied is true the migration is recorded as applied,
+// otherwise it is forgotten and runs again with the next Migrate
+func (m *Migrator) Resolve(ctx context.Context, version int, applied bool) (err error) {
+	resolver, ok := m.driver.(Resolver)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrResolveUnsupported, m.driver.Dialect())
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if uerr := unlock(ctx); uerr != nil && err == nil {
+			err = uerr
+		}
+	}()
+
+	return resolver.ResolveMigration(ctx, m.migrationsTable, version, applied)
+}
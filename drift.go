@@ -0,0 +1,309 @@
+package simplemigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrDriftUnsupported is returned when the driver cannot detect schema drift
+	ErrDriftUnsupported = errors.New("driver does not support drift detection")
+	// ErrSchemaDrift is returned when the live schema differs from the expected one
+	ErrSchemaDrift = errors.New("schema drift detected")
+)
+
+// ScratchProvider is an optional interface implemented by drivers
+// that can create an empty scratch database (or schema)
+// It is used together with SchemaDumper to detect schema drift
+type ScratchProvider interface {
+	// Scratch returns a driver for an empty scratch database
+	// and a function that removes it
+	Scratch(ctx context.Context) (DBDriver, func(context.Context) error, error)
+}
+
+// Drift kinds
+const (
+	DriftTable      = "table"
+	DriftColumn     = "column"
+	DriftConstraint = "constraint"
+	DriftIndex      = "index"
+	DriftView       = "view"
+)
+
+// Drift changes
+const (
+	// DriftMissing means that the object is expected but missing from the database
+	DriftMissing = "missing"
+	// DriftUnexpected means that the object exists in the database but is not expected
+	DriftUnexpected = "unexpected"
+	// DriftChanged means that the object exists but differs from the expected one
+	DriftChanged = "changed"
+)
+
+// Drift represents a difference between the expected and the live schema
+type Drift struct {
+	Kind     string
+	Name     string
+	Change   string
+	Expected string
+	Actual   string
+}
+
+// String returns a human readable description of the drift
+func (d Drift) String() string {
+	switch d.Change {
+	case DriftMissing:
+		return fmt.Sprintf("%s %s is missing", d.Kind, d.Name)
+	case DriftUnexpected:
+		return fmt.Sprintf("%s %s is unexpected", d.Kind, d.Name)
+	default:
+		return fmt.Sprintf("%s %s has changed: expected %q, got %q", d.Kind, d.Name, d.Expected, d.Actual)
+	}
+}
+
+// DetectDrift compares the live schema with the schema that the applied migrations describe
+// The expected schema is built by applying the migrations to a scratch database
+// The driver must implement SchemaDumper and ScratchProvider
+// It returns the differences (nil if there are none)
+func (m *Migrator) DetectDrift(ctx context.Context) ([]Drift, error) {
+	dumper, ok := m.driver.(SchemaDumper)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDriftUnsupported, m.driver.Dialect())
+	}
+
+	provider, ok := m.driver.(ScratchProvider)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDriftUnsupported, m.driver.Dialect())
+	}
+
+	localMigrations, err := m.readMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedMigrations, err := m.History(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the expected schema is only the deployed one if the applied migrations
+	// are the local files as they were applied
+	if err := checkSync(localMigrations, appliedMigrations); err != nil {
+		return nil, err
+	}
+
+	expected, err := m.expectedSchema(ctx, provider, localMigrations[:len(appliedMigrations)])
+	if err != nil {
+		return nil, err
+	}
+
+	actual, err := dumper.DumpSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSchemas(expected, actual), nil
+}
+
+// expectedSchema applies the migrations to a scratch database and returns its schema
+func (m *Migrator) expectedSchema(ctx context.Context, provider ScratchProvider, migrations []Migration) (_ *Schema, err error) {
+	scratch, cleanup, err := provider.Scratch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if cerr := cleanup(ctx); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	// a schema qualified migrations table lives outside of the compared schema,
+	// so the scratch database uses an unqualified one that is not compared
+	schemaName, table := SplitTableName(m.migrationsTable)
+
+	if err := scratch.CreateMigrationsTable(ctx, table); err != nil {
+		return nil, err
+	}
+
+	if len(migrations) > 0 {
+		if err := scratch.ApplyMigrations(ctx, table, false, migrations); err != nil {
+			return nil, fmt.Errorf("cannot apply migrations to the scratch database: %w", err)
+		}
+	}
+
+	dumper, ok := scratch.(SchemaDumper)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDriftUnsupported, scratch.Dialect())
+	}
+
+	expected, err := dumper.DumpSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if schemaName != "" {
+		expected.Tables = slices.DeleteFunc(expected.Tables, func(t Table) bool {
+			// postgres folds the name of the table to lower case
+			return strings.EqualFold(t.Name, table) || strings.EqualFold(t.Name, MetaTableName(table))
+		})
+	}
+
+	return expected, nil
+}
+
+// diffSchemas returns the differences between the expected and the actual schema
+func diffSchemas(expected, actual *Schema) []Drift {
+	expected.Sort()
+	actual.Sort()
+
+	var drifts []Drift
+
+	actualTables := map[string]*Table{}
+	for i := range actual.Tables {
+		actualTables[actual.Tables[i].Name] = &actual.Tables[i]
+	}
+
+	expectedTables := map[string]bool{}
+
+	for i := range expected.Tables {
+		e := &expected.Tables[i]
+		expectedTables[e.Name] = true
+
+		a, ok := actualTables[e.Name]
+		if !ok {
+			drifts = append(drifts, Drift{Kind: DriftTable, Name: e.Name, Change: DriftMissing})
+
+			continue
+		}
+
+		drifts = append(drifts, diffTables(e, a)...)
+	}
+
+	for i := range actual.Tables {
+		if !expectedTables[actual.Tables[i].Name] {
+			drifts = append(drifts, Drift{Kind: DriftTable, Name: actual.Tables[i].Name, Change: DriftUnexpected})
+		}
+	}
+
+	drifts = append(drifts, diffNamed(DriftIndex, "", indexDefinitions(expected), indexDefinitions(actual))...)
+	drifts = append(drifts, diffNamed(DriftView, "", viewDefinitions(expected), viewDefinitions(actual))...)
+
+	return drifts
+}
+
+// diffTables returns the differences in the columns and the constraints of two tables
+func diffTables(expected, actual *Table) []Drift {
+	drifts := diffNamed(DriftColumn, expected.Name+".", columnDefinitions(expected), columnDefinitions(actual))
+
+	return append(drifts, diffNamed(DriftConstraint, expected.Name+".", constraintDefinitions(expected), constraintDefinitions(actual))...)
+}
+
+// namedDefinitions are the names (in order) and the definitions of schema objects
+type namedDefinitions struct {
+	names []string
+	defs  map[string]string
+}
+
+func (n *namedDefinitions) add(name, def string) {
+	if n.defs == nil {
+		n.defs = map[string]string{}
+	}
+
+	n.names = append(n.names, name)
+	n.defs[name] = def
+}
+
+func columnDefinitions(t *Table) namedDefinitions {
+	var ans namedDefinitions
+
+	for _, c := range t.Columns {
+		ans.add(c.Name, columnDefinition(c))
+	}
+
+	return ans
+}
+
+func constraintDefinitions(t *Table) namedDefinitions {
+	var ans namedDefinitions
+
+	for _, c := range t.Constraints {
+		ans.add(c.Name, c.Definition)
+	}
+
+	return ans
+}
+
+func indexDefinitions(s *Schema) namedDefinitions {
+	var ans namedDefinitions
+
+	for _, idx := range s.Indexes {
+		ans.add(idx.Name, idx.DDL)
+	}
+
+	return ans
+}
+
+func viewDefinitions(s *Schema) namedDefinitions {
+	var ans namedDefinitions
+
+	for _, v := range s.Views {
+		ans.add(v.Name, v.DDL)
+	}
+
+	return ans
+}
+
+// diffNamed compares objects by name and definition
+func diffNamed(kind, prefix string, expected, actual namedDefinitions) []Drift {
+	var drifts []Drift
+
+	for _, name := range expected.names {
+		def, ok := actual.defs[name]
+		if !ok {
+			drifts = append(drifts, Drift{Kind: kind, Name: prefix + name, Change: DriftMissing, Expected: expected.defs[name]})
+
+			continue
+		}
+
+		if normalizeDefinition(def) != normalizeDefinition(expected.defs[name]) {
+			drifts = append(drifts, Drift{
+				Kind:     kind,
+				Name:     prefix + name,
+				Change:   DriftChanged,
+				Expected: expected.defs[name],
+				Actual:   def,
+			})
+		}
+	}
+
+	for _, name := range actual.names {
+		if _, ok := expected.defs[name]; !ok {
+			drifts = append(drifts, Drift{Kind: kind, Name: prefix + name, Change: DriftUnexpected, Actual: actual.defs[name]})
+		}
+	}
+
+	return drifts
+}
+
+func columnDefinition(c Column) string {
+	ans := c.Type
+
+	if c.NotNull {
+		ans += " NOT NULL"
+	}
+
+	if c.Default != "" {
+		ans += " DEFAULT " + c.Default
+	}
+
+	return ans
+}
+
+// normalizeDefinition collapses whitespace and trailing semicolons
+func normalizeDefinition(def string) string {
+	return strings.TrimRight(strings.Join(strings.Fields(def), " "), ";")
+}
@@ -0,0 +1,334 @@
+// Package drivertest contains a conformance suite for simplemigrate.DBDriver implementations.
+//
+// A driver package runs it from its tests:
+//
+//	func TestConformance(t *testing.T) {
+//		drivertest.Run(t, func(t *testing.T) simplemigrate.DBDriver {
+//			return newDriverForAnEmptyDatabase(t)
+//		})
+//	}
+package drivertest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+)
+
+const (
+	defaultTable = "schema_migrations"
+	customTable  = "drivertest_custom_migrations"
+)
+
+// InvalidStatement is the statement the suite uses to make a migration fail
+// It fails on every database, drivers that do not run SQL must reject it
+const InvalidStatement = "THIS IS NOT A VALID STATEMENT"
+
+// Factory returns a driver connected to an empty database
+// It is called once for every test of the suite
+// Resources should be released with t.Cleanup
+type Factory func(t *testing.T) simplemigrate.DBDriver
+
+// Run runs the conformance suite against the drivers returned by newDriver
+func Run(t *testing.T, newDriver Factory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, d simplemigrate.DBDriver)
+	}{
+		{"CreateMigrationsTable is idempotent", testCreateMigrationsTableIdempotent},
+		{"SelectMigrations is sorted by version", testSelectMigrationsOrder},
+		{"SelectMigrations round trips the migration", testRoundTrip},
+		{"ApplyMigrations rolls back the global transaction", testGlobalTransactionRollback},
+		{"ApplyMigrations applies each file atomically", testPerFileAtomicity},
+		{"ApplyMigrations stops when the context is canceled", testContextCancellation},
+		{"custom migrations table names", testCustomTable},
+		{"ApplyMigrations calls the apply hooks", testApplyHooks},
+		{"failed non-transactional migrations are dirty", testDirtyMigration},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fn(t, newDriver(t))
+		})
+	}
+}
+
+// migration returns a migration with the given version and statements
+func migration(version int, statements ...string) simplemigrate.Migration {
+	return simplemigrate.Migration{
+		Version:    version,
+		Fname:      fmt.Sprintf("%d_drivertest.sql", version),
+		Hash:       fmt.Sprintf("hash-%d", version),
+		Statements: statements,
+	}
+}
+
+// createTable returns a statement that creates a table
+// The statement fails if the table already exists,
+// so it can be used to check that a table has been rolled back
+func createTable(name string) string {
+	return "CREATE TABLE " + name + " (id INTEGER)"
+}
+
+func setup(t *testing.T, d simplemigrate.DBDriver, table string) {
+	t.Helper()
+
+	require.NoError(t, d.CreateMigrationsTable(context.Background(), table))
+}
+
+func versions(t *testing.T, d simplemigrate.DBDriver, table string) []int {
+	t.Helper()
+
+	migrations, err := d.SelectMigrations(context.Background(), table)
+	require.NoError(t, err)
+
+	ans := make([]int, 0, len(migrations))
+	for i := range migrations {
+		ans = append(ans, migrations[i].Version)
+	}
+
+	return ans
+}
+
+func testCreateMigrationsTableIdempotent(t *testing.T, d simplemigrate.DBDriver) {
+	ctx := context.Background()
+
+	setup(t, d, defaultTable)
+
+	require.NoError(t, d.ApplyMigrations(ctx, defaultTable, false, []simplemigrate.Migration{
+		migration(1, createTable("drivertest_a")),
+	}))
+
+	require.NoError(t, d.CreateMigrationsTable(ctx, defaultTable))
+
+	require.Equal(t, []int{1}, versions(t, d, defaultTable))
+}
+
+func testSelectMigrationsOrder(t *testing.T, d simplemigrate.DBDriver) {
+	ctx := context.Background()
+
+	setup(t, d, defaultTable)
+
+	require.Empty(t, versions(t, d, defaultTable))
+
+	for _, v := range []int{2, 3, 1} {
+		require.NoError(t, d.ApplyMigrations(ctx, defaultTable, false, []simplemigrate.Migration{
+			migration(v, createTable(fmt.Sprintf("drivertest_%d", v))),
+		}))
+	}
+
+	require.Equal(t, []int{1, 2, 3}, versions(t, d, defaultTable))
+}
+
+func testRoundTrip(t *testing.T, d simplemigrate.DBDriver) {
+	ctx := context.Background()
+
+	setup(t, d, defaultTable)
+
+	m := migration(1, createTable("drivertest_a"))
+	m.AppliedBy = "drivertest"
+	m.Hostname = "drivertest-host"
+	m.AppVersion = "v1.2.3"
+	m.ToolVersion = "v0.1.0"
+
+	before := time.Now().Add(-time.Second)
+
+	require.NoError(t, d.ApplyMigrations(ctx, defaultTable, false, []simplemigrate.Migration{m}))
+
+	after := time.Now().Add(time.Second)
+
+	migrations, err := d.SelectMigrations(ctx, defaultTable)
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+
+	got := migrations[0]
+	require.Equal(t, m.Version, got.Version)
+	require.Equal(t, m.Fname, got.Fname)
+	require.Equal(t, m.Hash, got.Hash)
+	require.NotNil(t, got.AppliedAt)
+	require.True(t, got.AppliedAt.After(before), "applied_at %s is before %s", got.AppliedAt, before)
+	require.True(t, got.AppliedAt.Before(after), "applied_at %s is after %s", got.AppliedAt, after)
+	require.GreaterOrEqual(t, got.ExecutionTime, time.Duration(0))
+	require.Equal(t, m.AppliedBy, got.AppliedBy)
+	require.Equal(t, m.Hostname, got.Hostname)
+	require.Equal(t, m.AppVersion, got.AppVersion)
+	require.Equal(t, m.ToolVersion, got.ToolVersion)
+}
+
+func testGlobalTransactionRollback(t *testing.T, d simplemigrate.DBDriver) {
+	skipWithoutTransactionalDDL(t, d)
+
+	ctx := context.Background()
+
+	setup(t, d, defaultTable)
+
+	err := d.ApplyMigrations(ctx, defaultTable, true, []simplemigrate.Migration{
+		migration(1, createTable("drivertest_a")),
+		migration(2, InvalidStatement),
+	})
+	require.Error(t, err)
+
+	require.Empty(t, versions(t, d, defaultTable))
+
+	// drivertest_a must have been rolled back
+	require.NoError(t, d.ApplyMigrations(ctx, defaultTable, true, []simplemigrate.Migration{
+		migration(1, createTable("drivertest_a")),
+	}))
+
+	require.Equal(t, []int{1}, versions(t, d, defaultTable))
+}
+
+func testPerFileAtomicity(t *testing.T, d simplemigrate.DBDriver) {
+	skipWithoutTransactionalDDL(t, d)
+
+	ctx := context.Background()
+
+	setup(t, d, defaultTable)
+
+	err := d.ApplyMigrations(ctx, defaultTable, false, []simplemigrate.Migration{
+		migration(1, createTable("drivertest_a")),
+		migration(2, createTable("drivertest_b"), InvalidStatement),
+		migration(3, createTable("drivertest_c")),
+	})
+	require.Error(t, err)
+
+	require.Equal(t, []int{1}, versions(t, d, defaultTable))
+
+	// drivertest_b must have been rolled back
+	require.NoError(t, d.ApplyMigrations(ctx, defaultTable, false, []simplemigrate.Migration{
+		migration(2, createTable("drivertest_b")),
+	}))
+
+	require.Equal(t, []int{1, 2}, versions(t, d, defaultTable))
+}
+
+// skipWithoutTransactionalDDL skips tests that rely on DDL being rolled back
+func skipWithoutTransactionalDDL(t *testing.T, d simplemigrate.DBDriver) {
+	t.Helper()
+
+	if r, ok := d.(simplemigrate.TransactionalDDLReporter); ok && !r.TransactionalDDL() {
+		t.Skip("driver does not support transactional DDL")
+	}
+}
+
+func testDirtyMigration(t *testing.T, d simplemigrate.DBDriver) {
+	if r, ok := d.(simplemigrate.TransactionalDDLReporter); !ok || r.TransactionalDDL() {
+		t.Skip("failed migrations of drivers with transactional DDL are rolled back")
+	}
+
+	ctx := context.Background()
+
+	setup(t, d, defaultTable)
+
+	err := d.ApplyMigrations(ctx, defaultTable, false, []simplemigrate.Migration{
+		migration(1, createTable("drivertest_a")),
+		migration(2, createTable("drivertest_b"), InvalidStatement),
+	})
+	require.Error(t, err)
+
+	migrations, err := d.SelectMigrations(ctx, defaultTable)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.False(t, migrations[0].Dirty)
+	require.True(t, migrations[1].Dirty)
+
+	resolver, ok := d.(simplemigrate.Resolver)
+	if !ok {
+		return
+	}
+
+	require.ErrorIs(t, resolver.ResolveMigration(ctx, defaultTable, 1, true), simplemigrate.ErrNotDirty)
+
+	require.NoError(t, resolver.ResolveMigration(ctx, defaultTable, 2, false))
+	require.Equal(t, []int{1}, versions(t, d, defaultTable))
+
+	err = d.ApplyMigrations(ctx, defaultTable, false, []simplemigrate.Migration{
+		migration(2, InvalidStatement),
+	})
+	require.Error(t, err)
+
+	require.NoError(t, resolver.ResolveMigration(ctx, defaultTable, 2, true))
+
+	migrations, err = d.SelectMigrations(ctx, defaultTable)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.False(t, migrations[1].Dirty)
+}
+
+func testContextCancellation(t *testing.T, d simplemigrate.DBDriver) {
+	setup(t, d, defaultTable)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, inTx := range []bool{false, true} {
+		err := d.ApplyMigrations(ctx, defaultTable, inTx, []simplemigrate.Migration{
+			migration(1, createTable("drivertest_a")),
+		})
+		require.Error(t, err)
+	}
+
+	require.Empty(t, versions(t, d, defaultTable))
+}
+
+func testCustomTable(t *testing.T, d simplemigrate.DBDriver) {
+	ctx := context.Background()
+
+	setup(t, d, customTable)
+	setup(t, d, defaultTable)
+
+	require.NoError(t, d.ApplyMigrations(ctx, customTable, false, []simplemigrate.Migration{
+		migration(1, createTable("drivertest_a")),
+	}))
+
+	require.Equal(t, []int{1}, versions(t, d, customTable))
+	require.Empty(t, versions(t, d, defaultTable))
+}
+
+func testApplyHooks(t *testing.T, d simplemigrate.DBDriver) {
+	setup(t, d, defaultTable)
+
+	var events []string
+
+	hooks := simplemigrate.ApplyHooks{
+		MigrationStart: func(ctx context.Context, m simplemigrate.Migration) (context.Context, func(error)) {
+			events = append(events, fmt.Sprintf("start %d", m.Version))
+
+			return ctx, func(err error) {
+				events = append(events, fmt.Sprintf("done %d %v", m.Version, err == nil))
+			}
+		},
+		StatementStart: func(ctx context.Context, m simplemigrate.Migration, idx int) (context.Context, func(int64, error)) {
+			events = append(events, fmt.Sprintf("statement %d.%d", m.Version, idx))
+
+			return ctx, func(int64, error) {}
+		},
+	}
+
+	ctx := simplemigrate.ContextWithApplyHooks(context.Background(), &hooks)
+
+	err := d.ApplyMigrations(ctx, defaultTable, false, []simplemigrate.Migration{
+		migration(1, createTable("drivertest_a"), createTable("drivertest_b")),
+		migration(2, InvalidStatement),
+	})
+	require.Error(t, err)
+
+	require.Equal(t, []string{
+		"start 1",
+		"statement 1.0",
+		"statement 1.1",
+		"done 1 true",
+		"start 2",
+		"statement 2.0",
+		"done 2 false",
+	}, events)
+}
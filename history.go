@@ -0,0 +1,112 @@
+package simplemigrate
+
+import (
+	"os"
+	"os/user"
+	"runtime/debug"
+)
+
+// modulePath is the module path of simplemigrate, used to find its version in the build info
+const modulePath = "github.com/gosom/simplemigrate"
+
+// unknownVersion is recorded when a version cannot be read from the build info
+const unknownVersion = "(devel)"
+
+// WithAppVersion is an option to set the version of the application that is
+// recorded with every applied migration
+// By default it is read from the build info of the binary
+func WithAppVersion(version string) Option {
+	return func(m *Migrator) error {
+		m.appVersion = version
+
+		return nil
+	}
+}
+
+// WithAppliedBy is an option to set who applies the migrations
+// By default it is the user that runs the process
+func WithAppliedBy(name string) Option {
+	return func(m *Migrator) error {
+		m.appliedBy = name
+
+		return nil
+	}
+}
+
+// stampMigrations sets who applies the migrations, from where and with which versions
+func (m *Migrator) stampMigrations(migrations []Migration) {
+	appliedBy := m.appliedBy
+	if appliedBy == "" {
+		appliedBy = currentUser()
+	}
+
+	appVersion := m.appVersion
+	if appVersion == "" {
+		appVersion = buildAppVersion()
+	}
+
+	hostname, _ := os.Hostname()
+	toolVersion := buildToolVersion()
+
+	for i := range migrations {
+		migrations[i].AppliedBy = appliedBy
+		migrations[i].Hostname = hostname
+		migrations[i].AppVersion = appVersion
+		migrations[i].ToolVersion = toolVersion
+	}
+}
+
+// currentUser returns the name of the user that runs the process
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// buildAppVersion returns the version of the main module of the binary
+// or its vcs revision for development builds
+func buildAppVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return unknownVersion
+	}
+
+	if info.Main.Version != "" && info.Main.Version != unknownVersion {
+		return info.Main.Version
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return unknownVersion
+}
+
+// buildToolVersion returns the version of simplemigrate the binary is built with
+func buildToolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return unknownVersion
+	}
+
+	if info.Main.Path == modulePath && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+
+	return unknownVersion
+}
+
+// Version returns the version of simplemigrate the binary is built with
+func Version() string {
+	return buildToolVersion()
+}
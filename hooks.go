@@ -0,0 +1,75 @@
+package simplemigrate
+
+import (
+	"context"
+	"time"
+)
+
+// ApplyHooks are callbacks invoked by drivers while migrations are applied
+// The Migrator passes them to the driver in the context of ApplyMigrations
+// Drivers do not use them directly, they call StartMigration and StartStatement instead
+type ApplyHooks struct {
+	// MigrationStart is called before the statements of a migration are executed
+	// It returns the context to use for the migration and a function
+	// that is called with the result of the migration
+	MigrationStart func(ctx context.Context, m Migration) (context.Context, func(err error))
+	// StatementStart is called before the statement with index idx of a migration is executed
+	// It returns the context to use for the statement and a function
+	// that is called with the number of affected rows and the result of the statement
+	StatementStart func(ctx context.Context, m Migration, idx int) (context.Context, func(rowsAffected int64, err error))
+	// StatementTimeout is the timeout that StatementStart sets on the context of every statement
+	StatementTimeout time.Duration
+}
+
+type applyHooksKey struct{}
+
+// ContextWithApplyHooks returns a copy of ctx that carries the hooks
+func ContextWithApplyHooks(ctx context.Context, hooks *ApplyHooks) context.Context {
+	return context.WithValue(ctx, applyHooksKey{}, hooks)
+}
+
+// applyHooksFromContext returns the hooks carried by ctx or nil
+func applyHooksFromContext(ctx context.Context) *ApplyHooks {
+	hooks, _ := ctx.Value(applyHooksKey{}).(*ApplyHooks)
+
+	return hooks
+}
+
+// StartMigration must be called by drivers before applying a migration
+// It returns the context that should be used for the migration
+// and a function that must be called with the result once the migration is done
+func StartMigration(ctx context.Context, m Migration) (context.Context, func(err error)) {
+	hooks := applyHooksFromContext(ctx)
+	if hooks == nil || hooks.MigrationStart == nil {
+		return ctx, func(error) {}
+	}
+
+	return hooks.MigrationStart(ctx, m)
+}
+
+// StartStatement must be called by drivers before executing a statement of a migration
+// idx is the index of the statement in m.Statements
+// It returns the context that should be used for the statement
+// and a function that must be called with the number of affected rows
+// (-1 if unknown) and the result once the statement is done
+func StartStatement(ctx context.Context, m Migration, idx int) (context.Context, func(rowsAffected int64, err error)) {
+	hooks := applyHooksFromContext(ctx)
+	if hooks == nil || hooks.StatementStart == nil {
+		return ctx, func(int64, error) {}
+	}
+
+	return hooks.StatementStart(ctx, m, idx)
+}
+
+// StatementTimeout returns the timeout of every statement set with
+// WithStatementTimeout or 0
+// Drivers that send several statements at once must execute them one by one
+// when it is set, so that each one runs with the context of StartStatement
+func StatementTimeout(ctx context.Context) time.Duration {
+	hooks := applyHooksFromContext(ctx)
+	if hooks == nil {
+		return 0
+	}
+
+	return hooks.StatementTimeout
+}
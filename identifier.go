@@ -0,0 +1,56 @@
+package simplemigrate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxIdentifierLength is the shortest identifier limit of the supported databases (postgres)
+const maxIdentifierLength = 63
+
+// ErrInvalidIdentifier is returned when a name is not a valid SQL identifier
+var ErrInvalidIdentifier = errors.New("invalid identifier")
+
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateTableName returns ErrInvalidIdentifier unless name is a table name
+// or a schema qualified (schema.table) table name
+// Every part must start with a letter or an underscore, contain only letters,
+// digits and underscores and be at most 63 characters long
+// The table must be at most 58 characters long, so that the name of its meta
+// table is not truncated by postgres to the name of the table itself
+func ValidateTableName(name string) error {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return fmt.Errorf("%w: %q has more than one schema qualifier", ErrInvalidIdentifier, name)
+	}
+
+	for i, part := range parts {
+		if !identifierRe.MatchString(part) {
+			return fmt.Errorf("%w: %q", ErrInvalidIdentifier, name)
+		}
+
+		maxLength := maxIdentifierLength
+		if i == len(parts)-1 {
+			maxLength -= len(metaTableSuffix)
+		}
+
+		if len(part) > maxLength {
+			return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidIdentifier, part, maxLength)
+		}
+	}
+
+	return nil
+}
+
+// SplitTableName splits a table name that was validated by ValidateTableName
+// into its schema (empty if it is not qualified) and the table
+func SplitTableName(name string) (schema, table string) {
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return "", name
+}
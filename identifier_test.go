@@ -0,0 +1,37 @@
+package simplemigrate_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+)
+
+func Test_ValidateTableName(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{
+		"schema_migrations", "SchemaMigrations", "ops.schema_migrations", "_t1",
+		strings.Repeat("t", 58), strings.Repeat("s", 63) + ".t",
+	} {
+		require.NoError(t, simplemigrate.ValidateTableName(name), name)
+	}
+
+	for _, name := range []string{
+		"", ".t", "ops.", "a.b.c", "my-table", "t$", "9t", "ops.\"t\"", string(make([]byte, 64)),
+		// the meta table of a longer table would be truncated to the name of the table
+		strings.Repeat("t", 59), "ops." + strings.Repeat("t", 59),
+	} {
+		require.ErrorIs(t, simplemigrate.ValidateTableName(name), simplemigrate.ErrInvalidIdentifier, name)
+	}
+
+	schema, table := simplemigrate.SplitTableName("ops.schema_migrations")
+	require.Equal(t, "ops", schema)
+	require.Equal(t, "schema_migrations", table)
+
+	schema, table = simplemigrate.SplitTableName("schema_migrations")
+	require.Empty(t, schema)
+	require.Equal(t, "schema_migrations", table)
+}
@@ -0,0 +1,464 @@
+// Package lint implements a simplemigrate.QueryValidator that flags
+// destructive and locking operations before they reach production.
+//
+// A rule can be suppressed for a whole migration file with a comment:
+//
+//	-- migrate:allow drop-column, rename
+package lint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gosom/simplemigrate"
+)
+
+// Rule identifiers, used in "-- migrate:allow" comments
+const (
+	RuleDropTable        = "drop-table"
+	RuleDropColumn       = "drop-column"
+	RuleAlterColumnType  = "alter-column-type"
+	RuleAddColumnNotNull = "add-column-not-null"
+	RuleCreateIndex      = "create-index-non-concurrent"
+	RuleRename           = "rename"
+)
+
+// AllowDirective is the comment used to suppress rules for a migration file
+const AllowDirective = "-- migrate:allow"
+
+// Rule describes a lint rule
+type Rule struct {
+	// ID is the identifier used in "-- migrate:allow" comments
+	ID string
+	// Description explains why the statement is risky
+	Description string
+	// Dialects are the dialects the rule applies to (all if empty)
+	Dialects []string
+}
+
+var rules = []Rule{
+	{
+		ID:          RuleDropTable,
+		Description: "dropping a table loses data and breaks code that still uses it",
+	},
+	{
+		ID:          RuleDropColumn,
+		Description: "dropping a column loses data and breaks code that still uses it",
+	},
+	{
+		ID:          RuleAlterColumnType,
+		Description: "changing the type of a column rewrites the table under an exclusive lock",
+		Dialects:    []string{"postgres", "mysql"},
+	},
+	{
+		ID:          RuleAddColumnNotNull,
+		Description: "adding a NOT NULL column without a default fails on tables that have rows",
+	},
+	{
+		ID:          RuleCreateIndex,
+		Description: "creating an index blocks writes to the table until it is built (CONCURRENTLY cannot run in a migration)",
+		Dialects:    []string{"postgres"},
+	},
+	{
+		ID:          RuleRename,
+		Description: "renaming a table or column breaks code that still uses the old name",
+	},
+}
+
+// Rules returns the rules of the linter
+func Rules() []Rule {
+	ans := make([]Rule, len(rules))
+	copy(ans, rules)
+
+	return ans
+}
+
+// Option represents a linter option
+type Option func(*validator)
+
+// WithDisabledRules disables the rules with the given ids
+func WithDisabledRules(ids ...string) Option {
+	return func(v *validator) {
+		for _, id := range ids {
+			v.disabled[id] = true
+		}
+	}
+}
+
+type validator struct {
+	disabled map[string]bool
+}
+
+// New creates a linter that flags risky statements
+// All rules are enabled by default
+func New(opts ...Option) simplemigrate.QueryValidator {
+	ans := validator{
+		disabled: map[string]bool{},
+	}
+
+	for _, opt := range opts {
+		opt(&ans)
+	}
+
+	return &ans
+}
+
+// ValidateQuery returns an error describing the risky statements of query
+// Rules allowed anywhere in the migration file of the query are not checked
+func (v *validator) ValidateQuery(ctx context.Context, dialect, query string) error {
+	allowed := allowedRules(query)
+
+	if migration, ok := simplemigrate.MigrationFromContext(ctx); ok {
+		for _, statement := range migration.Statements {
+			for id := range allowedRules(statement) {
+				allowed[id] = true
+			}
+		}
+	}
+
+	var violations []string
+
+	for _, stmt := range splitStatements(tokenize(query)) {
+		found := check(stmt)
+
+		for i := range rules {
+			r := &rules[i]
+
+			if !found[r.ID] || v.disabled[r.ID] || allowed[r.ID] || !appliesTo(r, dialect) {
+				continue
+			}
+
+			violations = append(violations,
+				fmt.Sprintf("%s: %s (add %q to allow it)", r.ID, r.Description, AllowDirective+" "+r.ID))
+		}
+	}
+
+	if len(violations) > 0 {
+		return errors.New(strings.Join(violations, "; "))
+	}
+
+	return nil
+}
+
+// allowedRules returns the rules allowed by "-- migrate:allow" comments in query
+func allowedRules(query string) map[string]bool {
+	ans := map[string]bool{}
+
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+
+		if !strings.HasPrefix(line, AllowDirective) {
+			continue
+		}
+
+		for _, id := range strings.FieldsFunc(strings.TrimPrefix(line, AllowDirective), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		}) {
+			ans[strings.ToLower(id)] = true
+		}
+	}
+
+	return ans
+}
+
+func appliesTo(r *Rule, dialect string) bool {
+	if len(r.Dialects) == 0 {
+		return true
+	}
+
+	for _, d := range r.Dialects {
+		if d == dialect {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check returns the ids of the rules that the statement violates
+func check(stmt []string) map[string]bool {
+	found := map[string]bool{}
+
+	switch {
+	case hasPrefix(stmt, "DROP", "TABLE"):
+		found[RuleDropTable] = true
+	case hasPrefix(stmt, "RENAME", "TABLE"):
+		found[RuleRename] = true
+	case hasPrefix(stmt, "CREATE", "INDEX"), hasPrefix(stmt, "CREATE", "UNIQUE", "INDEX"):
+		if !contains(stmt, "CONCURRENTLY") {
+			found[RuleCreateIndex] = true
+		}
+	case hasPrefix(stmt, "ALTER", "TABLE"):
+		for _, clause := range splitClauses(skipTableName(stmt[2:])) {
+			checkAlterClause(clause, found)
+		}
+	}
+
+	return found
+}
+
+// checkAlterClause checks a single clause of an ALTER TABLE statement
+func checkAlterClause(clause []string, found map[string]bool) {
+	if len(clause) == 0 {
+		return
+	}
+
+	switch clause[0] {
+	case "DROP":
+		if len(clause) > 1 && !isConstraintKeyword(clause[1]) {
+			found[RuleDropColumn] = true
+		}
+	case "RENAME":
+		found[RuleRename] = true
+	case "MODIFY", "CHANGE":
+		found[RuleAlterColumnType] = true
+	case "ALTER":
+		rest := clause[1:]
+		if hasPrefix(rest, "COLUMN") {
+			rest = rest[1:]
+		}
+
+		if len(rest) > 0 && (hasPrefix(rest[1:], "TYPE") || hasPrefix(rest[1:], "SET", "DATA", "TYPE")) {
+			found[RuleAlterColumnType] = true
+		}
+	case "ADD":
+		if len(clause) > 1 && isConstraintKeyword(clause[1]) {
+			return
+		}
+
+		if containsSeq(clause, "NOT", "NULL") && !contains(clause, "DEFAULT") {
+			found[RuleAddColumnNotNull] = true
+		}
+	}
+}
+
+// isConstraintKeyword returns true if the token introduces something that is not a column
+func isConstraintKeyword(token string) bool {
+	switch token {
+	case "CONSTRAINT", "INDEX", "KEY", "PRIMARY", "FOREIGN", "UNIQUE", "CHECK", "PARTITION", "DEFAULT", "NOT":
+		return true
+	default:
+		return false
+	}
+}
+
+// skipTableName skips the optional IF EXISTS / ONLY and the table name of an ALTER TABLE
+func skipTableName(tokens []string) []string {
+	if hasPrefix(tokens, "IF", "EXISTS") {
+		tokens = tokens[2:]
+	}
+
+	if hasPrefix(tokens, "ONLY") {
+		tokens = tokens[1:]
+	}
+
+	return skipQualifiedName(tokens)
+}
+
+// skipQualifiedName skips a name that may be qualified and quoted
+// "schema"."table" is tokenized as ? . ?, schema."table" as SCHEMA. ? and
+// "schema".table as ? .TABLE
+func skipQualifiedName(tokens []string) []string {
+	if len(tokens) == 0 {
+		return tokens
+	}
+
+	last := tokens[0]
+	tokens = tokens[1:]
+
+	for len(tokens) > 0 && (strings.HasSuffix(last, ".") || strings.HasPrefix(tokens[0], ".")) {
+		last = tokens[0]
+		tokens = tokens[1:]
+	}
+
+	return tokens
+}
+
+// splitClauses splits tokens at commas that are not within parentheses
+func splitClauses(tokens []string) [][]string {
+	var (
+		ans   [][]string
+		start int
+		depth int
+	)
+
+	for i, t := range tokens {
+		switch t {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				ans = append(ans, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(ans, tokens[start:])
+}
+
+// splitStatements splits tokens at semicolons
+func splitStatements(tokens []string) [][]string {
+	var (
+		ans   [][]string
+		start int
+	)
+
+	for i, t := range tokens {
+		if t == ";" {
+			if i > start {
+				ans = append(ans, tokens[start:i])
+			}
+
+			start = i + 1
+		}
+	}
+
+	if start < len(tokens) {
+		ans = append(ans, tokens[start:])
+	}
+
+	return ans
+}
+
+// tokenize returns the upper-cased tokens of query
+// Comments are dropped and literals or quoted identifiers are replaced by placeholders
+func tokenize(query string) []string {
+	var (
+		tokens []string
+		word   strings.Builder
+	)
+
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			flush()
+
+			i = skipUntil(query, i+2, "\n")
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			flush()
+
+			i = skipUntil(query, i+2, "*/")
+		case c == '\'' || c == '"' || c == '`':
+			flush()
+
+			i = skipQuoted(query, i, c)
+			tokens = append(tokens, "?")
+		case c == '$' && word.Len() == 0:
+			if end := strings.IndexByte(query[i+1:], '$'); end >= 0 && isDollarTag(query[i+1:i+1+end]) {
+				tag := query[i : i+end+2]
+
+				i = skipUntil(query, i+len(tag), tag)
+				tokens = append(tokens, "?")
+
+				continue
+			}
+
+			word.WriteByte(c)
+		case c == '(' || c == ')' || c == ',' || c == ';':
+			flush()
+
+			tokens = append(tokens, string(c))
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		default:
+			word.WriteString(strings.ToUpper(string(c)))
+		}
+	}
+
+	flush()
+
+	return tokens
+}
+
+// skipUntil returns the index of the last byte of the first occurrence of end in s after from
+// or the index of the last byte of s
+func skipUntil(s string, from int, end string) int {
+	if from >= len(s) {
+		return len(s) - 1
+	}
+
+	idx := strings.Index(s[from:], end)
+	if idx == -1 {
+		return len(s) - 1
+	}
+
+	return from + idx + len(end) - 1
+}
+
+// skipQuoted returns the index of the closing quote of the literal starting at from
+// Doubled quotes are treated as escaped quotes
+func skipQuoted(s string, from int, quote byte) int {
+	for i := from + 1; i < len(s); i++ {
+		if s[i] != quote {
+			continue
+		}
+
+		if i+1 < len(s) && s[i+1] == quote {
+			i++
+
+			continue
+		}
+
+		return i
+	}
+
+	return len(s) - 1
+}
+
+func isDollarTag(tag string) bool {
+	for _, r := range tag {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasPrefix(tokens []string, prefix ...string) bool {
+	if len(tokens) < len(prefix) {
+		return false
+	}
+
+	for i := range prefix {
+		if tokens[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsSeq(tokens []string, seq ...string) bool {
+	for i := range tokens {
+		if hasPrefix(tokens[i:], seq...) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,117 @@
+package lint_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/lint"
+)
+
+func TestValidateQuery(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		dialect string
+		query   string
+		rule    string
+	}{
+		{"drop table", "sqlite", "DROP TABLE users;", lint.RuleDropTable},
+		{"drop column", "postgres", "ALTER TABLE users DROP COLUMN email;", lint.RuleDropColumn},
+		{"drop column without keyword", "postgres", "alter table users drop email", lint.RuleDropColumn},
+		{"drop constraint", "postgres", "ALTER TABLE users DROP CONSTRAINT users_email_key", ""},
+		{"alter column type", "postgres", "ALTER TABLE users ALTER COLUMN age TYPE BIGINT", lint.RuleAlterColumnType},
+		{"alter column set data type", "postgres", "ALTER TABLE users ALTER age SET DATA TYPE BIGINT", lint.RuleAlterColumnType},
+		{"alter column named type", "postgres", "ALTER TABLE users ALTER COLUMN type SET DEFAULT 'a'", ""},
+		{"mysql modify column", "mysql", "ALTER TABLE users MODIFY COLUMN age BIGINT", lint.RuleAlterColumnType},
+		{"add not null without default", "sqlite", "ALTER TABLE users ADD COLUMN age INT NOT NULL", lint.RuleAddColumnNotNull},
+		{"add not null with default", "sqlite", "ALTER TABLE users ADD COLUMN age INT NOT NULL DEFAULT 0", ""},
+		{"add nullable column", "postgres", "ALTER TABLE users ADD COLUMN age INT", ""},
+		{"create index", "postgres", "CREATE INDEX idx_users_email ON users (email)", lint.RuleCreateIndex},
+		{"create unique index", "postgres", "CREATE UNIQUE INDEX idx_users_email ON users (email)", lint.RuleCreateIndex},
+		{"create index concurrently", "postgres", "CREATE INDEX CONCURRENTLY idx_users_email ON users (email)", ""},
+		{"create index on sqlite", "sqlite", "CREATE INDEX idx_users_email ON users (email)", ""},
+		{"rename table", "postgres", "ALTER TABLE users RENAME TO accounts", lint.RuleRename},
+		{"rename column", "sqlite", "ALTER TABLE users RENAME COLUMN email TO mail", lint.RuleRename},
+		{"second clause", "postgres", "ALTER TABLE users ADD COLUMN age INT, DROP COLUMN email", lint.RuleDropColumn},
+		{"qualified table", "postgres", "ALTER TABLE public.users DROP COLUMN email", lint.RuleDropColumn},
+		{"quoted qualified table", "postgres", `ALTER TABLE "public"."users" DROP COLUMN email`, lint.RuleDropColumn},
+		{"quoted schema", "postgres", `ALTER TABLE "public".users DROP COLUMN email`, lint.RuleDropColumn},
+		{"quoted table", "postgres", `ALTER TABLE IF EXISTS ONLY public."users" DROP COLUMN email`, lint.RuleDropColumn},
+		{"quoted qualified table rename", "postgres", `ALTER TABLE "public"."users" RENAME TO accounts`, lint.RuleRename},
+		{"second statement", "sqlite", "CREATE TABLE a (id INT); DROP TABLE b;", lint.RuleDropTable},
+		{"comment", "sqlite", "-- DROP TABLE users\nCREATE TABLE a (id INT)", ""},
+		{"literal", "sqlite", "INSERT INTO logs (msg) VALUES ('DROP TABLE users; ')", ""},
+		{"dollar quoted", "postgres", "CREATE FUNCTION f() RETURNS void AS $$ DROP TABLE users; $$ LANGUAGE sql", ""},
+		{"create table", "postgres", "CREATE TABLE users (id INT NOT NULL)", ""},
+	}
+
+	v := lint.New()
+
+	for i := range tests {
+		tc := tests[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := v.ValidateQuery(context.Background(), tc.dialect, tc.query)
+			if tc.rule == "" {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.rule+":")
+		})
+	}
+}
+
+func TestValidateQuery_Suppression(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should allow rules in the same statement", func(t *testing.T) {
+		t.Parallel()
+
+		err := lint.New().ValidateQuery(context.Background(), "sqlite",
+			"-- migrate:allow drop-table\nDROP TABLE users;")
+		require.NoError(t, err)
+	})
+
+	t.Run("should allow rules anywhere in the migration file", func(t *testing.T) {
+		t.Parallel()
+
+		migration := simplemigrate.Migration{
+			Fname: "2_cleanup.sql",
+			Statements: []string{
+				"-- migrate:allow drop-column, rename\nALTER TABLE users RENAME TO accounts;\n",
+				"\nALTER TABLE accounts DROP COLUMN email;",
+			},
+		}
+
+		ctx := simplemigrate.ContextWithMigration(context.Background(), migration)
+
+		for _, stmt := range migration.Statements {
+			require.NoError(t, lint.New().ValidateQuery(ctx, "postgres", stmt))
+		}
+	})
+
+	t.Run("should not allow other rules", func(t *testing.T) {
+		t.Parallel()
+
+		err := lint.New().ValidateQuery(context.Background(), "sqlite",
+			"-- migrate:allow drop-column\nDROP TABLE users;")
+		require.Error(t, err)
+	})
+
+	t.Run("should skip disabled rules", func(t *testing.T) {
+		t.Parallel()
+
+		err := lint.New(lint.WithDisabledRules(lint.RuleDropTable)).
+			ValidateQuery(context.Background(), "sqlite", "DROP TABLE users;")
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,73 @@
+package simplemigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrLockTimeout is returned when the migration lock cannot be acquired in time
+	ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+	// ErrTransactionalDDLUnsupported is returned when WithInTransaction is used
+	// with a driver whose DDL statements are not transactional
+	ErrTransactionalDDLUnsupported = errors.New("driver does not support transactional DDL")
+)
+
+// Locker is an optional interface implemented by drivers that can take a
+// database lock, so that concurrent Migrate calls do not apply the same migrations
+type Locker interface {
+	// Lock acquires the lock for migrationsTable and returns a function that releases it
+	// It returns ErrLockTimeout if the lock is not acquired before ctx is done
+	Lock(ctx context.Context, migrationsTable string) (func(context.Context) error, error)
+}
+
+// TransactionalDDLReporter is an optional interface implemented by drivers
+// that report whether DDL statements can be rolled back
+// Drivers that do not implement it are assumed to support transactional DDL
+type TransactionalDDLReporter interface {
+	// TransactionalDDL returns true if DDL statements can be rolled back
+	TransactionalDDL() bool
+}
+
+// WithLockTimeout is an option to set how long Migrate waits for the migration lock
+// It is only used when the driver implements Locker
+// By default it waits until the context of Migrate is done
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) error {
+		m.lockTimeout = timeout
+
+		return nil
+	}
+}
+
+// supportsTransactionalDDL returns true unless the driver reports that DDL is not transactional
+func supportsTransactionalDDL(driver DBDriver) bool {
+	reporter, ok := driver.(TransactionalDDLReporter)
+
+	return !ok || reporter.TransactionalDDL()
+}
+
+// lock acquires the migration lock if the driver implements Locker
+// It returns a function that releases it
+func (m *Migrator) lock(ctx context.Context) (func(context.Context) error, error) {
+	locker, ok := m.driver.(Locker)
+	if !ok {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	lockCtx, cancel := withTimeout(ctx, m.lockTimeout)
+	defer cancel()
+
+	unlock, err := locker.Lock(lockCtx, m.migrationsTable)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil, fmt.Errorf("%w: %s", ErrLockTimeout, err)
+		}
+
+		return nil, err
+	}
+
+	return unlock, nil
+}
@@ -0,0 +1,357 @@
+// Package memdriver contains a stateful in-memory simplemigrate.DBDriver
+// for the unit tests of applications that embed the Migrator.
+//
+// It does not parse SQL: it records the applied migrations and the executed
+// statements, emulates transactions and can inject faults:
+//
+//	driver := memdriver.New(memdriver.FailAtVersion(3, nil))
+//	err := simplemigrate.New(driver, opts...).Migrate(ctx)
+package memdriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gosom/simplemigrate"
+)
+
+var (
+	// ErrInjected is the error returned by injected faults when no error is given
+	ErrInjected = errors.New("memdriver: injected fault")
+	// ErrTransient can be wrapped by injected errors that should be retried
+	// (see simplemigrate.WithRetry)
+	ErrTransient = errors.New("memdriver: transient error")
+	// ErrClosed is returned when the driver is used after Close
+	ErrClosed = errors.New("memdriver: driver is closed")
+	// ErrNoMigrationsTable is returned when the migrations table does not exist
+	ErrNoMigrationsTable = errors.New("memdriver: migrations table does not exist")
+)
+
+const defaultDialect = "memory"
+
+// Statement is a statement executed by the driver
+type Statement struct {
+	// Version is the version of the migration of the statement
+	Version int
+	// Fname is the file name of the migration of the statement
+	Fname string
+	// Index is the index of the statement in the migration
+	Index int
+	// Query is the statement
+	Query string
+	// Err is the error returned for the statement (nil on success)
+	Err error
+}
+
+// Option represents a driver option
+type Option func(*Driver)
+
+// WithDialect sets the dialect reported by the driver
+// It is "memory" by default
+func WithDialect(dialect string) Option {
+	return func(d *Driver) {
+		d.dialect = dialect
+	}
+}
+
+// FailAtVersion makes the migration with the given version fail before
+// any of its statements is executed
+// If err is nil ErrInjected is returned
+func FailAtVersion(version int, err error) Option {
+	return func(d *Driver) {
+		d.failAtVersion[version] = orInjected(err)
+	}
+}
+
+// FailOnStatement makes the k-th (1-based) statement executed by the driver fail
+// If err is nil ErrInjected is returned
+func FailOnStatement(k int, err error) Option {
+	return func(d *Driver) {
+		d.failOnStatement[k] = orInjected(err)
+	}
+}
+
+// FailOnQuery makes every statement that is equal to query fail
+// If err is nil ErrInjected is returned
+func FailOnQuery(query string, err error) Option {
+	return func(d *Driver) {
+		d.failOnQuery[query] = orInjected(err)
+	}
+}
+
+// Driver is an in-memory simplemigrate.DBDriver
+// It is safe for concurrent use
+type Driver struct {
+	mu              sync.Mutex
+	dialect         string
+	closed          bool
+	tables          map[string][]simplemigrate.Migration
+	executed        []Statement
+	failAtVersion   map[int]error
+	failOnStatement map[int]error
+	failOnQuery     map[string]error
+}
+
+var (
+	_ simplemigrate.DBDriver        = (*Driver)(nil)
+	_ simplemigrate.RetryClassifier = (*Driver)(nil)
+)
+
+// New creates a new in-memory driver
+func New(opts ...Option) *Driver {
+	ans := Driver{
+		dialect:         defaultDialect,
+		tables:          map[string][]simplemigrate.Migration{},
+		failAtVersion:   map[int]error{},
+		failOnStatement: map[int]error{},
+		failOnQuery:     map[string]error{},
+	}
+
+	for _, opt := range opts {
+		opt(&ans)
+	}
+
+	return &ans
+}
+
+// Dialect returns the dialect set with WithDialect
+func (d *Driver) Dialect() string {
+	return d.dialect
+}
+
+// Close closes the driver
+func (d *Driver) Close(_ context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+
+	return nil
+}
+
+// IsRetryable returns true for errors that wrap ErrTransient
+func (d *Driver) IsRetryable(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// CreateMigrationsTable creates the migrations table
+// If the table already exists, it does nothing
+func (d *Driver) CreateMigrationsTable(_ context.Context, migrationsTable string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	if _, ok := d.tables[migrationsTable]; !ok {
+		d.tables[migrationsTable] = nil
+	}
+
+	return nil
+}
+
+// SelectMigrations selects all migrations from the migrations table
+// It returns a sorted slice (by Version ascending) of migrations or an error
+func (d *Driver) SelectMigrations(ctx context.Context, migrationsTable string) ([]simplemigrate.Migration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, ErrClosed
+	}
+
+	rows, ok := d.tables[migrationsTable]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoMigrationsTable, migrationsTable)
+	}
+
+	ans := make([]simplemigrate.Migration, len(rows))
+	copy(ans, rows)
+
+	return ans, nil
+}
+
+// ApplyMigrations applies migrations
+// If inTx is true, nothing is recorded unless all migrations succeed,
+// otherwise each migration is recorded once all its statements succeed
+func (d *Driver) ApplyMigrations(ctx context.Context, migrationsTable string, inTx bool, migrations []simplemigrate.Migration) error {
+	if err := d.checkTable(migrationsTable); err != nil {
+		return err
+	}
+
+	var pending []simplemigrate.Migration
+
+	for i := range migrations {
+		applied, err := d.applyOne(ctx, migrations[i])
+		if err != nil {
+			return err
+		}
+
+		if inTx {
+			pending = append(pending, applied)
+
+			continue
+		}
+
+		d.record(migrationsTable, applied)
+	}
+
+	d.record(migrationsTable, pending...)
+
+	return nil
+}
+
+// checkTable returns an error if the driver is closed or the table does not exist
+func (d *Driver) checkTable(migrationsTable string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	if _, ok := d.tables[migrationsTable]; !ok {
+		return fmt.Errorf("%w: %s", ErrNoMigrationsTable, migrationsTable)
+	}
+
+	return nil
+}
+
+// applyOne executes the statements of a migration
+// The hooks are called without holding mu
+func (d *Driver) applyOne(ctx context.Context, m simplemigrate.Migration) (_ simplemigrate.Migration, err error) {
+	start := time.Now()
+
+	ctx, done := simplemigrate.StartMigration(ctx, m)
+
+	defer func() {
+		done(err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return m, err
+	}
+
+	d.mu.Lock()
+	err = d.failAtVersion[m.Version]
+	d.mu.Unlock()
+
+	if err != nil {
+		return m, err
+	}
+
+	for i, query := range m.Statements {
+		if err := d.exec(ctx, m, i, query); err != nil {
+			return m, err
+		}
+	}
+
+	now := time.Now().UTC()
+
+	m.AppliedAt = &now
+	m.ExecutionTime = time.Since(start)
+
+	return m, nil
+}
+
+// exec records the execution of a statement
+func (d *Driver) exec(ctx context.Context, m simplemigrate.Migration, idx int, query string) (err error) {
+	ctx, done := simplemigrate.StartStatement(ctx, m, idx)
+
+	defer func() {
+		done(0, err)
+	}()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err = ctx.Err()
+	if err == nil {
+		err = d.failOnStatement[len(d.executed)+1]
+	}
+
+	if err == nil {
+		err = d.failOnQuery[query]
+	}
+
+	d.executed = append(d.executed, Statement{
+		Version: m.Version,
+		Fname:   m.Fname,
+		Index:   idx,
+		Query:   query,
+		Err:     err,
+	})
+
+	return err
+}
+
+// record adds rows to the migrations table
+func (d *Driver) record(migrationsTable string, migrations ...simplemigrate.Migration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows := d.tables[migrationsTable]
+
+	for i := range migrations {
+		m := migrations[i]
+		m.Statements = nil
+
+		rows = append(rows, m)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Version < rows[j].Version
+	})
+
+	d.tables[migrationsTable] = rows
+}
+
+// Applied returns the migrations recorded in the migrations table
+func (d *Driver) Applied(migrationsTable string) []simplemigrate.Migration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ans := make([]simplemigrate.Migration, len(d.tables[migrationsTable]))
+	copy(ans, d.tables[migrationsTable])
+
+	return ans
+}
+
+// Executed returns the statements executed by the driver in order
+// Statements of migrations that were rolled back are included
+func (d *Driver) Executed() []Statement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ans := make([]Statement, len(d.executed))
+	copy(ans, d.executed)
+
+	return ans
+}
+
+// ClearFaults removes all injected faults
+func (d *Driver) ClearFaults() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.failAtVersion = map[int]error{}
+	d.failOnStatement = map[int]error{}
+	d.failOnQuery = map[string]error{}
+}
+
+func orInjected(err error) error {
+	if err == nil {
+		return ErrInjected
+	}
+
+	return err
+}
@@ -0,0 +1,129 @@
+package memdriver_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/drivertest"
+	"github.com/gosom/simplemigrate/memdriver"
+)
+
+const tbl = "schema_migrations"
+
+var folder = fstest.MapFS{
+	"1_users.sql": {Data: []byte("CREATE TABLE users (id INT);")},
+	"2_posts.sql": {Data: []byte("CREATE TABLE posts (id INT);\n-- migrate:next\nCREATE INDEX idx ON posts (id);")},
+	"3_tags.sql":  {Data: []byte("CREATE TABLE tags (id INT);")},
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	drivertest.Run(t, func(*testing.T) simplemigrate.DBDriver {
+		return memdriver.New(memdriver.FailOnQuery(drivertest.InvalidStatement, nil))
+	})
+}
+
+func versions(migrations []simplemigrate.Migration) []int {
+	ans := make([]int, 0, len(migrations))
+	for i := range migrations {
+		ans = append(ans, migrations[i].Version)
+	}
+
+	return ans
+}
+
+func TestDriver(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should record applied migrations and executed statements", func(t *testing.T) {
+		t.Parallel()
+
+		driver := memdriver.New()
+
+		err := simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder)).Migrate(context.Background())
+		require.NoError(t, err)
+
+		applied := driver.Applied(tbl)
+		require.Equal(t, []int{1, 2, 3}, versions(applied))
+		require.NotNil(t, applied[0].AppliedAt)
+		require.Equal(t, "2_posts.sql", applied[1].Fname)
+
+		executed := driver.Executed()
+		require.Len(t, executed, 4)
+		require.Equal(t, 2, executed[2].Version)
+		require.Equal(t, 1, executed[2].Index)
+		require.Contains(t, executed[2].Query, "CREATE INDEX")
+
+		err = simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder)).Migrate(context.Background())
+		require.NoError(t, err)
+		require.Len(t, driver.Executed(), 4)
+	})
+
+	t.Run("should fail at a version", func(t *testing.T) {
+		t.Parallel()
+
+		driver := memdriver.New(memdriver.FailAtVersion(2, nil))
+
+		err := simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder)).Migrate(context.Background())
+		require.ErrorIs(t, err, memdriver.ErrInjected)
+
+		require.Equal(t, []int{1}, versions(driver.Applied(tbl)))
+	})
+
+	t.Run("should fail on a statement", func(t *testing.T) {
+		t.Parallel()
+
+		errBoom := fmt.Errorf("boom")
+
+		driver := memdriver.New(memdriver.FailOnStatement(3, errBoom))
+
+		err := simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder)).Migrate(context.Background())
+		require.ErrorIs(t, err, errBoom)
+
+		require.Equal(t, []int{1}, versions(driver.Applied(tbl)))
+
+		executed := driver.Executed()
+		require.Len(t, executed, 3)
+		require.ErrorIs(t, executed[2].Err, errBoom)
+	})
+
+	t.Run("should roll back all migrations in a transaction", func(t *testing.T) {
+		t.Parallel()
+
+		driver := memdriver.New(memdriver.FailAtVersion(3, nil))
+
+		err := simplemigrate.New(driver,
+			simplemigrate.WithEmbedFS(folder),
+			simplemigrate.WithInTransaction(),
+		).Migrate(context.Background())
+		require.ErrorIs(t, err, memdriver.ErrInjected)
+
+		require.Empty(t, driver.Applied(tbl))
+	})
+
+	t.Run("should apply the remaining migrations once the faults are cleared", func(t *testing.T) {
+		t.Parallel()
+
+		driver := memdriver.New(memdriver.FailAtVersion(2, fmt.Errorf("%w: locked", memdriver.ErrTransient)))
+
+		m := simplemigrate.New(driver,
+			simplemigrate.WithEmbedFS(folder),
+			simplemigrate.WithRetry(simplemigrate.RetryPolicy{MaxAttempts: 2}),
+		)
+
+		err := m.Migrate(context.Background())
+		require.ErrorIs(t, err, memdriver.ErrTransient)
+		require.Equal(t, []int{1}, versions(driver.Applied(tbl)))
+
+		driver.ClearFaults()
+
+		require.NoError(t, m.Migrate(context.Background()))
+		require.Equal(t, []int{1, 2, 3}, versions(driver.Applied(tbl)))
+	})
+}
@@ -0,0 +1,38 @@
+package simplemigrate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MetaVersion is the version of the layout of the migrations table
+// Drivers store it in the meta table and upgrade older layouts to it by
+// adding the columns that are missing:
+//
+//	1: version, fname, hash, applied_at
+//	2: execution_ms, applied_by, hostname, app_version, tool_version
+//	3: dirty
+const MetaVersion = 3
+
+// metaTableSuffix is appended to the name of the migrations table to name the meta table
+const metaTableSuffix = "_meta"
+
+// ErrMetaVersion is returned when the migrations table was written by a newer
+// version of simplemigrate, so this version cannot use it without corrupting it
+var ErrMetaVersion = errors.New("migrations table has an unsupported layout")
+
+// MetaTableName returns the name of the table that stores the meta version
+// of migrationsTable
+func MetaTableName(migrationsTable string) string {
+	return migrationsTable + metaTableSuffix
+}
+
+// CheckMetaVersion returns ErrMetaVersion if version is newer than MetaVersion
+func CheckMetaVersion(version int) error {
+	if version > MetaVersion {
+		return fmt.Errorf("%w: layout version %d is newer than %d, upgrade simplemigrate",
+			ErrMetaVersion, version, MetaVersion)
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package simplemigrate
+
+import (
+	"time"
+)
+
+// Metrics represents a collector of migration metrics
+// The metrics package contains an implementation that exposes
+// them in the Prometheus text format
+type Metrics interface {
+	// MigrationApplied is called when a migration has been applied
+	MigrationApplied(m Migration)
+	// MigrationFailed is called when a migration has failed
+	MigrationFailed(m Migration)
+	// ObserveMigrationDuration records how long a single migration took
+	ObserveMigrationDuration(m Migration, d time.Duration)
+	// ObserveMigrateDuration records how long a Migrate call took
+	ObserveMigrateDuration(d time.Duration)
+	// SetSchemaVersion records the current schema version
+	SetSchemaVersion(version int)
+}
+
+// nopMetrics is the Metrics used when WithMetrics is not set
+type nopMetrics struct{}
+
+func (nopMetrics) MigrationApplied(Migration)                        {}
+func (nopMetrics) MigrationFailed(Migration)                         {}
+func (nopMetrics) ObserveMigrationDuration(Migration, time.Duration) {}
+func (nopMetrics) ObserveMigrateDuration(time.Duration)              {}
+func (nopMetrics) SetSchemaVersion(int)                              {}
@@ -0,0 +1,181 @@
+// Package metrics implements simplemigrate.Metrics and exposes the
+// collected metrics in the Prometheus text exposition format.
+// It only depends on the standard library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gosom/simplemigrate"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the histogram buckets (in seconds) used by New
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900}
+
+var _ simplemigrate.Metrics = (*Collector)(nil)
+
+// Collector collects migration metrics
+// It implements simplemigrate.Metrics and http.Handler
+type Collector struct {
+	mu                sync.Mutex
+	applied           uint64
+	failed            uint64
+	migrationDuration *histogram
+	migrateDuration   *histogram
+	schemaVersion     int
+}
+
+// New creates a new Collector
+// If no buckets are given DefaultBuckets are used
+func New(buckets ...float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	return &Collector{
+		migrationDuration: newHistogram(buckets),
+		migrateDuration:   newHistogram(buckets),
+	}
+}
+
+// MigrationApplied increments the counter of applied migrations
+func (c *Collector) MigrationApplied(_ simplemigrate.Migration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.applied++
+}
+
+// MigrationFailed increments the counter of failed migrations
+func (c *Collector) MigrationFailed(_ simplemigrate.Migration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failed++
+}
+
+// ObserveMigrationDuration records how long a single migration took
+func (c *Collector) ObserveMigrationDuration(_ simplemigrate.Migration, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.migrationDuration.observe(d.Seconds())
+}
+
+// ObserveMigrateDuration records how long a Migrate call took
+func (c *Collector) ObserveMigrateDuration(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.migrateDuration.observe(d.Seconds())
+}
+
+// SetSchemaVersion records the current schema version
+func (c *Collector) SetSchemaVersion(version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.schemaVersion = version
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+
+	_, _ = c.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	writeHeader(cw, "simplemigrate_migrations_applied_total", "Total number of applied migrations.", "counter")
+	fmt.Fprintf(cw, "simplemigrate_migrations_applied_total %d\n", c.applied)
+
+	writeHeader(cw, "simplemigrate_migrations_failed_total", "Total number of failed migrations.", "counter")
+	fmt.Fprintf(cw, "simplemigrate_migrations_failed_total %d\n", c.failed)
+
+	writeHeader(cw, "simplemigrate_migration_duration_seconds", "Duration of a single migration.", "histogram")
+	c.migrationDuration.write(cw, "simplemigrate_migration_duration_seconds")
+
+	writeHeader(cw, "simplemigrate_migrate_duration_seconds", "Duration of a whole migrate run.", "histogram")
+	c.migrateDuration.write(cw, "simplemigrate_migrate_duration_seconds")
+
+	writeHeader(cw, "simplemigrate_schema_version", "Current schema version.", "gauge")
+	fmt.Fprintf(cw, "simplemigrate_schema_version %d\n", c.schemaVersion)
+
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+
+	return cw.n, nil
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// histogram is a cumulative histogram
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(upper), h.counts[i])
+	}
+
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
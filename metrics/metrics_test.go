@@ -0,0 +1,66 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/metrics"
+)
+
+func TestCollector_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	c := metrics.New(0.1, 1)
+
+	m := simplemigrate.Migration{Version: 1, Fname: "1_demo.sql"}
+
+	c.MigrationApplied(m)
+	c.MigrationFailed(m)
+	c.ObserveMigrationDuration(m, 50*time.Millisecond)
+	c.ObserveMigrationDuration(m, 2*time.Second)
+	c.ObserveMigrateDuration(500 * time.Millisecond)
+	c.SetSchemaVersion(7)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	res := rec.Result()
+	defer res.Body.Close()
+
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	expected := `# HELP simplemigrate_migrations_applied_total Total number of applied migrations.
+# TYPE simplemigrate_migrations_applied_total counter
+simplemigrate_migrations_applied_total 1
+# HELP simplemigrate_migrations_failed_total Total number of failed migrations.
+# TYPE simplemigrate_migrations_failed_total counter
+simplemigrate_migrations_failed_total 1
+# HELP simplemigrate_migration_duration_seconds Duration of a single migration.
+# TYPE simplemigrate_migration_duration_seconds histogram
+simplemigrate_migration_duration_seconds_bucket{le="0.1"} 1
+simplemigrate_migration_duration_seconds_bucket{le="1"} 1
+simplemigrate_migration_duration_seconds_bucket{le="+Inf"} 2
+simplemigrate_migration_duration_seconds_sum 2.05
+simplemigrate_migration_duration_seconds_count 2
+# HELP simplemigrate_migrate_duration_seconds Duration of a whole migrate run.
+# TYPE simplemigrate_migrate_duration_seconds histogram
+simplemigrate_migrate_duration_seconds_bucket{le="0.1"} 0
+simplemigrate_migrate_duration_seconds_bucket{le="1"} 1
+simplemigrate_migrate_duration_seconds_bucket{le="+Inf"} 1
+simplemigrate_migrate_duration_seconds_sum 0.5
+simplemigrate_migrate_duration_seconds_count 1
+# HELP simplemigrate_schema_version Current schema version.
+# TYPE simplemigrate_schema_version gauge
+simplemigrate_schema_version 7
+`
+
+	require.Equal(t, expected, string(body))
+}
@@ -0,0 +1,81 @@
+// Package oteltracer adapts an OpenTelemetry tracer to simplemigrate.Tracer
+package oteltracer
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/gosom/simplemigrate"
+)
+
+// instrumentationName is used when New is called with a nil tracer
+const instrumentationName = "github.com/gosom/simplemigrate"
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// New creates a simplemigrate.Tracer that creates spans using t
+// If t is nil the global tracer provider is used
+func New(t trace.Tracer) simplemigrate.Tracer {
+	if t == nil {
+		t = otel.Tracer(instrumentationName)
+	}
+
+	return &tracer{tracer: t}
+}
+
+// Start creates an OpenTelemetry span
+func (t *tracer) Start(ctx context.Context, name string, attrs ...simplemigrate.Attribute) (context.Context, simplemigrate.Span) {
+	ctx, s := t.tracer.Start(ctx, name, trace.WithAttributes(convert(attrs)...))
+
+	return ctx, &span{span: s}
+}
+
+type span struct {
+	span trace.Span
+}
+
+// SetAttributes sets attributes on the span
+func (s *span) SetAttributes(attrs ...simplemigrate.Attribute) {
+	s.span.SetAttributes(convert(attrs)...)
+}
+
+// RecordError records the error and marks the span as failed
+func (s *span) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End ends the span
+func (s *span) End() {
+	s.span.End()
+}
+
+func convert(attrs []simplemigrate.Attribute) []attribute.KeyValue {
+	ans := make([]attribute.KeyValue, 0, len(attrs))
+
+	for _, a := range attrs {
+		switch v := a.Value.(type) {
+		case string:
+			ans = append(ans, attribute.String(a.Key, v))
+		case int:
+			ans = append(ans, attribute.Int(a.Key, v))
+		case int64:
+			ans = append(ans, attribute.Int64(a.Key, v))
+		case float64:
+			ans = append(ans, attribute.Float64(a.Key, v))
+		case bool:
+			ans = append(ans, attribute.Bool(a.Key, v))
+		default:
+			ans = append(ans, attribute.String(a.Key, fmt.Sprint(v)))
+		}
+	}
+
+	return ans
+}
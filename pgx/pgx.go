@@ -0,0 +1,540 @@
+package pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gosom/simplemigrate"
+)
+
+// lockPrefix is the prefix of the key of the advisory lock
+const lockPrefix = "simplemigrate:"
+
+// errMissingResult is returned when the server sends fewer results than statements
+var errMissingResult = errors.New("missing batch result")
+
+func init() {
+	simplemigrate.RegisterDriver("pgx", open)
+}
+
+// DB is implemented by *pgxpool.Pool and *pgx.Conn
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// driver is a struct that represents a pgx driver
+type driver struct {
+	db DB
+}
+
+// New creates a new pgx driver on top of a *pgxpool.Pool or a *pgx.Conn
+// The statements of a migration file are sent to the server in a single batch
+// using the extended protocol, and each statement starts when the result of
+// the previous one has been read
+// Files with a statement that holds several SQL commands, and all files when a
+// statement timeout is set, are executed one statement at a time using the
+// simple protocol instead
+func New(db DB) simplemigrate.DBDriver {
+	return &driver{db: db}
+}
+
+// Connect creates a connection pool for a postgres database
+func Connect(ctx context.Context, uri string) (*pgxpool.Pool, error) {
+	return pgxpool.New(ctx, uri)
+}
+
+// open connects to the database of a pgx:// url
+// It is a postgres:// url with another scheme, so that it does not clash
+// with the postgres driver
+func open(ctx context.Context, connURL string) (simplemigrate.DBDriver, error) {
+	pool, err := Connect(ctx, "postgres"+connURL[strings.IndexByte(connURL, ':'):])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+
+		return nil, err
+	}
+
+	return New(pool), nil
+}
+
+// Close closes the pool or the connection passed to New
+// Do not call it if the pool is shared with the rest of the application
+func (d *driver) Close(ctx context.Context) error {
+	switch db := d.db.(type) {
+	case *pgxpool.Pool:
+		db.Close()
+
+		return nil
+	case interface{ Close(context.Context) error }:
+		return db.Close(ctx)
+	default:
+		return nil
+	}
+}
+
+// Dialect returns the database dialect
+func (d *driver) Dialect() string {
+	return "postgres"
+}
+
+// IsRetryable returns true for serialization failures, deadlocks
+// and lock timeouts
+func (d *driver) IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	switch pgErr.Code {
+	case "40001", // serialization_failure
+		"40P01", // deadlock_detected
+		"55P03": // lock_not_available
+		return true
+	default:
+		return false
+	}
+}
+
+// Lock acquires a session level advisory lock on a dedicated connection
+// It waits until ctx is done
+func (d *driver) Lock(ctx context.Context, migrationsTable string) (func(context.Context) error, error) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(lockPrefix + migrationsTable))
+	key := int64(h.Sum64())
+
+	conn, release, err := d.dedicatedConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		release()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %s", simplemigrate.ErrLockTimeout, err)
+		}
+
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		defer release()
+
+		_, err := conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", key)
+
+		return err
+	}, nil
+}
+
+// dedicatedConn returns a connection that is not used by other goroutines
+// and a function that releases it
+// A pool connection is acquired, a *pgx.Conn is used as is
+func (d *driver) dedicatedConn(ctx context.Context) (DB, func(), error) {
+	pool, ok := d.db.(*pgxpool.Pool)
+	if !ok {
+		return d.db, func() {}, nil
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, conn.Release, nil
+}
+
+// CreateMigrationsTable creates the migrations table or upgrades its layout
+// to simplemigrate.MetaVersion
+// The schema of a schema qualified table is created if it is missing
+func (d *driver) CreateMigrationsTable(ctx context.Context, migrationsTable string) error {
+	if schema, _ := simplemigrate.SplitTableName(migrationsTable); schema != "" {
+		if _, err := d.db.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+quote(schema)); err != nil {
+			return err
+		}
+	}
+
+	return d.upgrade(ctx, migrationsTable)
+}
+
+// SelectMigrations selects all migrations from the migrations table
+// It returns a sorted slice (by Version ascending) of migrations or an error
+func (d *driver) SelectMigrations(ctx context.Context, migrationsTable string) ([]simplemigrate.Migration, error) {
+	rows, err := d.db.Query(ctx,
+		"SELECT version, fname, hash, applied_at, execution_ms, applied_by, hostname, app_version, tool_version, dirty FROM "+
+			quote(migrationsTable)+" ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var migrations []simplemigrate.Migration
+
+	for rows.Next() {
+		var (
+			m                                            simplemigrate.Migration
+			executionMs                                  *int64
+			appliedBy, hostname, appVersion, toolVersion *string
+		)
+
+		err := rows.Scan(&m.Version, &m.Fname, &m.Hash, &m.AppliedAt,
+			&executionMs, &appliedBy, &hostname, &appVersion, &toolVersion, &m.Dirty)
+		if err != nil {
+			return nil, err
+		}
+
+		if executionMs != nil {
+			m.ExecutionTime = time.Duration(*executionMs) * time.Millisecond
+		}
+
+		m.AppliedBy = deref(appliedBy)
+		m.Hostname = deref(hostname)
+		m.AppVersion = deref(appVersion)
+		m.ToolVersion = deref(toolVersion)
+
+		migrations = append(migrations, m)
+	}
+
+	return migrations, rows.Err()
+}
+
+// quote quotes every part of a (possibly schema qualified) table name
+// The name is folded to lower case first, like postgres folds unquoted names
+func quote(name string) string {
+	schema, table := simplemigrate.SplitTableName(strings.ToLower(name))
+	if schema == "" {
+		return pgx.Identifier{table}.Sanitize()
+	}
+
+	return pgx.Identifier{schema, table}.Sanitize()
+}
+
+// deref returns the value of a nullable column or "" if it is NULL
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+// ApplyMigrations applies migrations to the database
+// migrationsTable is the name of the migrations table
+// If inTx is true, it applies all migrations in a transaction
+// It returns an error if one occurs
+func (d *driver) ApplyMigrations(ctx context.Context, migrationsTable string, inTx bool, migrations []simplemigrate.Migration) error {
+	if inTx {
+		tx, err := d.db.Begin(ctx)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = tx.Rollback(ctx)
+		}()
+
+		err = d.applyMigrations(ctx, migrationsTable, tx, migrations)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit(ctx)
+	}
+
+	return d.applyMigrations(ctx, migrationsTable, nil, migrations)
+}
+
+func (d *driver) applyMigrations(ctx context.Context, migrationsTable string, tx pgx.Tx, migrations []simplemigrate.Migration) error {
+	insertQ := "INSERT INTO " + quote(migrationsTable) +
+		" (version, fname, hash, applied_at, execution_ms, applied_by, hostname, app_version, tool_version)" +
+		" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+
+	for _, m := range migrations {
+		if err := d.applyOne(ctx, insertQ, tx, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *driver) applyOne(ctx context.Context, insertQ string, tx pgx.Tx, m simplemigrate.Migration) (err error) {
+	ctx, done := simplemigrate.StartMigration(ctx, m)
+
+	defer func() {
+		done(err)
+	}()
+
+	start := time.Now()
+
+	trans := tx
+	if trans == nil {
+		trans, err = d.db.Begin(ctx)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = trans.Rollback(ctx)
+		}()
+	}
+
+	// SET LOCAL lasts until the end of the transaction, so in a shared one
+	// the timeouts of the previous file are reset when ctx has no deadline
+	if _, ok := ctx.Deadline(); ok || tx != nil {
+		if err = setServerTimeouts(ctx, trans); err != nil {
+			return err
+		}
+	}
+
+	if simplemigrate.StatementTimeout(ctx) > 0 || slices.ContainsFunc(m.Statements, multipleCommands) {
+		err = execEach(ctx, trans, m)
+	} else {
+		err = execBatch(ctx, trans, m)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = trans.Exec(ctx, insertQ, m.Version, m.Fname, m.Hash, time.Now().UTC(),
+		time.Since(start).Milliseconds(), m.AppliedBy, m.Hostname, m.AppVersion, m.ToolVersion)
+	if err != nil {
+		return err
+	}
+
+	if tx == nil {
+		return trans.Commit(ctx)
+	}
+
+	return nil
+}
+
+// execBatch sends all the statements of migration m in a single batch
+// and reads their results in order
+// The server runs the statements one after the other, so a statement is
+// reported as started when the result of the previous one has been read
+func execBatch(ctx context.Context, tx pgx.Tx, m simplemigrate.Migration) error {
+	if len(m.Statements) == 0 {
+		return nil
+	}
+
+	batch := &pgconn.Batch{}
+	for _, query := range m.Statements {
+		batch.ExecParams(query, nil, nil, nil, nil)
+	}
+
+	mrr := tx.Conn().PgConn().ExecBatch(ctx, batch)
+
+	for i := range m.Statements {
+		_, stmtDone := simplemigrate.StartStatement(ctx, m, i)
+
+		if !mrr.NextResult() {
+			err := mrr.Close()
+			if err == nil {
+				err = errMissingResult
+			}
+
+			stmtDone(-1, err)
+
+			return statementError(m, i, err)
+		}
+
+		tag, err := mrr.ResultReader().Close()
+		if err != nil {
+			stmtDone(-1, err)
+			_ = mrr.Close()
+
+			return statementError(m, i, err)
+		}
+
+		stmtDone(tag.RowsAffected(), nil)
+	}
+
+	return mrr.Close()
+}
+
+// execEach executes the statements of migration m one at a time with the
+// simple protocol, which accepts several commands in a statement
+// Each statement runs with the context of StartStatement, so the server
+// timeouts are set to its deadline while it runs
+func execEach(ctx context.Context, tx pgx.Tx, m simplemigrate.Migration) error {
+	migrationDeadline, _ := ctx.Deadline()
+
+	for i, query := range m.Statements {
+		stmtCtx, stmtDone := simplemigrate.StartStatement(ctx, m, i)
+
+		rowsAffected, err := execStatement(ctx, stmtCtx, tx, query, migrationDeadline)
+
+		stmtDone(rowsAffected, err)
+
+		if err != nil {
+			return statementError(m, i, err)
+		}
+	}
+
+	return nil
+}
+
+// execStatement executes query with stmtCtx
+// If stmtCtx has a deadline other than the one of the migration, the server
+// timeouts are set to it and restored to the ones of ctx afterwards
+func execStatement(ctx, stmtCtx context.Context, tx pgx.Tx, query string, migrationDeadline time.Time) (int64, error) {
+	deadline, ok := stmtCtx.Deadline()
+	ownDeadline := ok && !deadline.Equal(migrationDeadline)
+
+	if ownDeadline {
+		if err := setServerTimeouts(stmtCtx, tx); err != nil {
+			return -1, err
+		}
+	}
+
+	results, err := tx.Conn().PgConn().Exec(stmtCtx, query).ReadAll()
+	if err != nil {
+		return -1, err
+	}
+
+	var rowsAffected int64
+	for _, r := range results {
+		rowsAffected += r.CommandTag.RowsAffected()
+	}
+
+	if ownDeadline {
+		if err := setServerTimeouts(ctx, tx); err != nil {
+			return -1, err
+		}
+	}
+
+	return rowsAffected, nil
+}
+
+// multipleCommands returns true if query holds more than one SQL command
+// Semicolons in literals, quoted identifiers, dollar quoted strings and
+// comments are ignored
+func multipleCommands(query string) bool {
+	ended := false
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			i = skipPast(query, i+2, "\n")
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			i = skipPast(query, i+2, "*/")
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		case c == ';':
+			ended = true
+		case ended:
+			return true
+		case c == '\'' || c == '"':
+			i = skipPast(query, i+1, string(c))
+		case c == '$':
+			if end := strings.IndexByte(query[i+1:], '$'); end >= 0 && isDollarTag(query[i+1:i+1+end]) {
+				tag := query[i : i+end+2]
+				i = skipPast(query, i+len(tag), tag)
+			}
+		}
+	}
+
+	return false
+}
+
+// skipPast returns the index of the last byte of the first occurrence of end
+// in s at or after from, or the index of the last byte of s
+// Doubled quotes within a quoted string are found as two occurrences in a row
+func skipPast(s string, from int, end string) int {
+	idx := strings.Index(s[from:], end)
+	if idx < 0 {
+		return len(s) - 1
+	}
+
+	return from + idx + len(end) - 1
+}
+
+// isDollarTag returns true if tag can be the tag of a dollar quoted string
+func isDollarTag(tag string) bool {
+	for i, c := range tag {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// statementError adds the details of a postgres error to err
+func statementError(m simplemigrate.Migration, idx int, err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return fmt.Errorf("%s statement %d: %w", m.Fname, idx+1, err)
+	}
+
+	details := []string{}
+
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"detail", pgErr.Detail},
+		{"hint", pgErr.Hint},
+		{"where", pgErr.Where},
+		{"schema", pgErr.SchemaName},
+		{"table", pgErr.TableName},
+		{"column", pgErr.ColumnName},
+		{"constraint", pgErr.ConstraintName},
+	} {
+		if f.value != "" {
+			details = append(details, f.name+": "+f.value)
+		}
+	}
+
+	if pgErr.Position > 0 {
+		details = append(details, fmt.Sprintf("position: %d", pgErr.Position))
+	}
+
+	if len(details) == 0 {
+		return fmt.Errorf("%s statement %d: %w", m.Fname, idx+1, err)
+	}
+
+	return fmt.Errorf("%s statement %d: %w (%s)", m.Fname, idx+1, err, strings.Join(details, ", "))
+}
+
+// setServerTimeouts sets statement_timeout and lock_timeout for the transaction
+// to the time left until the deadline of ctx, so the server cancels the
+// statements instead of only the client giving up
+// Without a deadline they are set to their defaults
+func setServerTimeouts(ctx context.Context, tx pgx.Tx) error {
+	value := "DEFAULT"
+
+	if deadline, ok := ctx.Deadline(); ok {
+		value = strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)
+	}
+
+	_, err := tx.Exec(ctx, "SET LOCAL statement_timeout = "+value)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "SET LOCAL lock_timeout = "+value)
+
+	return err
+}
@@ -0,0 +1,138 @@
+package pgx
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/gosom/simplemigrate"
+)
+
+// addedColumns are the columns that the later meta versions add to the
+// migrations table, in order
+var addedColumns = []struct {
+	name       string
+	definition string
+}{
+	{"execution_ms", "BIGINT"},
+	{"applied_by", "TEXT"},
+	{"hostname", "TEXT"},
+	{"app_version", "TEXT"},
+	{"tool_version", "TEXT"},
+	{"dirty", "BOOLEAN NOT NULL DEFAULT FALSE"},
+}
+
+// createTable returns the statement that creates migrationsTable with the
+// layout of meta version 1
+func createTable(migrationsTable string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + quote(migrationsTable) + ` (
+		version INTEGER NOT NULL PRIMARY KEY,
+		fname TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`
+}
+
+// upgrade brings the layout of migrationsTable to simplemigrate.MetaVersion
+// in a transaction and records it in the meta table
+// It refuses to touch tables with a newer layout
+// Only the missing columns are added
+func (d *driver) upgrade(ctx context.Context, migrationsTable string) error {
+	metaTable := quote(simplemigrate.MetaTableName(migrationsTable))
+
+	_, err := d.db.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+metaTable+" (version INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	version, recorded, err := d.metaVersion(ctx, migrationsTable)
+	if err != nil {
+		return err
+	}
+
+	if err := simplemigrate.CheckMetaVersion(version); err != nil {
+		return err
+	}
+
+	if recorded && version == simplemigrate.MetaVersion {
+		return nil
+	}
+
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if _, err := tx.Exec(ctx, createTable(migrationsTable)); err != nil {
+		return err
+	}
+
+	columns, err := tableColumns(ctx, tx, migrationsTable)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+
+	for _, c := range addedColumns {
+		if !columns[c.name] {
+			missing = append(missing, "ADD COLUMN "+c.name+" "+c.definition)
+		}
+	}
+
+	if len(missing) > 0 {
+		if _, err := tx.Exec(ctx, "ALTER TABLE "+quote(migrationsTable)+" "+strings.Join(missing, ", ")); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM "+metaTable); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "INSERT INTO "+metaTable+" (version) VALUES ($1)", simplemigrate.MetaVersion); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// metaVersion returns the meta version of migrationsTable recorded in the
+// meta table and whether it is recorded
+func (d *driver) metaVersion(ctx context.Context, migrationsTable string) (int, bool, error) {
+	var version int
+
+	err := d.db.QueryRow(ctx, "SELECT version FROM "+quote(simplemigrate.MetaTableName(migrationsTable))).Scan(&version)
+
+	switch {
+	case err == nil:
+		return version, true, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return 0, false, nil
+	default:
+		return 0, false, err
+	}
+}
+
+// tableColumns returns the names of the columns of migrationsTable
+func tableColumns(ctx context.Context, tx pgx.Tx, migrationsTable string) (map[string]bool, error) {
+	rows, err := tx.Query(ctx, "SELECT * FROM "+quote(migrationsTable)+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+
+	ans := map[string]bool{}
+	for _, f := range rows.FieldDescriptions() {
+		ans[f.Name] = true
+	}
+
+	rows.Close()
+
+	return ans, rows.Err()
+}
@@ -3,173 +3,295 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq" // postgres driver
+	"github.com/lib/pq"
 
 	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/sqldriver"
 )
 
-// TODO postgres driver & sqlite driver are almost identical, can I refactor?
+// Dialect describes postgres to sqldriver
+var Dialect = sqldriver.Dialect{
+	Name:             "postgres",
+	Placeholder:      sqldriver.Dollar,
+	TimestampType:    "TIMESTAMPTZ",
+	QuoteIdentifier:  quoteIdentifier,
+	CreateSchemas:    true,
+	TransactionalDDL: true,
+	IsRetryable:      isRetryable,
+	StatementTimeout: statementTimeout,
+}
+
+// quoteIdentifier quotes an identifier folded to lower case
+// Postgres folds unquoted identifiers to lower case, so the table of
+// WithMigrationTable("SchemaMigrations") has always been schemamigrations
+func quoteIdentifier(name string) string {
+	return pq.QuoteIdentifier(strings.ToLower(name))
+}
+
+func init() {
+	simplemigrate.RegisterDriver("postgres", open)
+	simplemigrate.RegisterDriver("postgresql", open)
+}
 
-// driver is a struct that represents a sqlite driver
+// driver is a struct that represents a postgres driver
 type driver struct {
-	db *sql.DB
+	*sqldriver.Driver
 }
 
-// New creates a new sqlite driver
+// New creates a new postgres driver
 func New(db *sql.DB) simplemigrate.DBDriver {
-	return &driver{db: db}
+	return &driver{Driver: sqldriver.New(db, Dialect)}
 }
 
-// Connect connects to a sqlite database
+// Connect connects to a postgres database
 func Connect(uri string) (*sql.DB, error) {
 	return sql.Open("postgres", uri)
 }
 
-// Close closes the connection to the database
-func (d *driver) Close(_ context.Context) error {
-	return d.db.Close()
+// open connects to the database of a postgres:// or postgresql:// url
+func open(ctx context.Context, connURL string) (simplemigrate.DBDriver, error) {
+	db, err := Connect(connURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	return New(db), nil
 }
 
-// Dialect returns the database dialect
-func (d *driver) Dialect() string {
-	return "postgres"
+// isRetryable returns true for serialization failures, deadlocks
+// and lock timeouts
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	switch pqErr.Code {
+	case "40001", // serialization_failure
+		"40P01", // deadlock_detected
+		"55P03": // lock_not_available
+		return true
+	default:
+		return false
+	}
 }
 
-// CreateMigrationsTable creates the migrations table
-// If the table already exists, it does nothing
-func (d *driver) CreateMigrationsTable(_ context.Context, migrationsTable string) error {
-	_, err := d.db.Exec(`
-		CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
-			version INTEGER NOT NULL PRIMARY KEY,
-			fname TEXT NOT NULL,
-			hash TEXT NOT NULL,
-			applied_at TIMESTAMPTZ NOT NULL
-		)
-	`)
+// statementTimeout sets statement_timeout and lock_timeout for the transaction,
+// so the server cancels the statement instead of only the client giving up
+func statementTimeout(timeout time.Duration) (set, reset string) {
+	ms := timeout.Milliseconds()
 
-	return err
+	return fmt.Sprintf("SET LOCAL statement_timeout = %d; SET LOCAL lock_timeout = %d", ms, ms),
+		"SET LOCAL statement_timeout TO DEFAULT; SET LOCAL lock_timeout TO DEFAULT"
 }
 
-// SelectMigrations selects all migrations from the migrations table
-// It returns a sorted slice (by Version ascending) of migrations or an error
-func (d *driver) SelectMigrations(ctx context.Context, migrationsTable string) ([]simplemigrate.Migration, error) {
-	//nolint:gosec // migrations table should be safe
-	rows, err := d.db.QueryContext(ctx,
-		"SELECT version, fname, hash, applied_at FROM "+migrationsTable+" ORDER BY version")
+// DumpSchema returns the schema of the current schema (search_path) of the database
+// It is built from information_schema and pg_catalog
+func (d *driver) DumpSchema(ctx context.Context) (*simplemigrate.Schema, error) {
+	schema := simplemigrate.Schema{Dialect: d.Dialect()}
+
+	tables := map[string]*simplemigrate.Table{}
+
+	err := d.queryRows(ctx, `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+		ORDER BY table_name
+	`, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+
+		schema.Tables = append(schema.Tables, simplemigrate.Table{Name: name})
+
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	defer rows.Close()
+	for i := range schema.Tables {
+		tables[schema.Tables[i].Name] = &schema.Tables[i]
+	}
 
-	var migrations []simplemigrate.Migration
+	err = d.queryRows(ctx, `
+		SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+			COALESCE(pg_get_expr(ad.adbin, ad.adrelid), '')
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+		WHERE n.nspname = current_schema() AND c.relkind IN ('r', 'p')
+			AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY c.relname, a.attnum
+	`, func(rows *sql.Rows) error {
+		var (
+			table string
+			c     simplemigrate.Column
+		)
 
-	for rows.Next() {
-		var m simplemigrate.Migration
+		if err := rows.Scan(&table, &c.Name, &c.Type, &c.NotNull, &c.Default); err != nil {
+			return err
+		}
 
-		err := rows.Scan(&m.Version, &m.Fname, &m.Hash, &m.AppliedAt)
-		if err != nil {
-			return nil, err
+		if t, ok := tables[table]; ok {
+			t.Columns = append(t.Columns, c)
 		}
 
-		migrations = append(migrations, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return migrations, nil
-}
-
-// ApplyMigrations applies migrations to the database
-// migrationsTable is the name of the migrations table
-// If inTx is true, it applies all migrations in a transaction
-// It returns an error if one occurs
-func (d *driver) ApplyMigrations(ctx context.Context, migrationsTable string, inTx bool, migrations []simplemigrate.Migration) error {
-	if inTx {
-		fmt.Println("Applying migrations in transaction")
+	err = d.queryRows(ctx, `
+		SELECT c.relname, con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema()
+		ORDER BY c.relname, con.conname
+	`, func(rows *sql.Rows) error {
+		var (
+			table string
+			c     simplemigrate.Constraint
+		)
 
-		tx, err := d.db.BeginTx(ctx, nil)
-		if err != nil {
+		if err := rows.Scan(&table, &c.Name, &c.Definition); err != nil {
 			return err
 		}
 
-		defer func() {
-			_ = tx.Rollback()
-		}()
-
-		err = d.applyMigrations(ctx, migrationsTable, tx, migrations)
-		if err != nil {
-			return err
+		if t, ok := tables[table]; ok {
+			t.Constraints = append(t.Constraints, c)
 		}
 
-		return tx.Commit()
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return d.applyMigrations(ctx, migrationsTable, nil, migrations)
-}
+	// indexes that back a constraint are created by the constraint
+	err = d.queryRows(ctx, `
+		SELECT i.relname, t.relname, pg_get_indexdef(i.oid), current_schema()
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN pg_class t ON t.oid = x.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE n.nspname = current_schema()
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = x.indexrelid)
+		ORDER BY t.relname, i.relname
+	`, func(rows *sql.Rows) error {
+		var (
+			idx        simplemigrate.Index
+			schemaName string
+		)
 
-func (d *driver) applyMigrations(ctx context.Context, migrationsTable string, tx *sql.Tx, migrations []simplemigrate.Migration) error {
-	insertQ := "INSERT INTO " + migrationsTable + " (version, fname, hash, applied_at) VALUES ($1, $2, $3, $4)"
+		if err := rows.Scan(&idx.Name, &idx.Table, &idx.DDL, &schemaName); err != nil {
+			return err
+		}
 
-	for _, m := range migrations {
-		fmt.Printf("%s...", m.Fname)
+		idx.DDL = unqualify(idx.DDL, schemaName)
 
-		if err := d.applyOne(ctx, insertQ, tx, m); err != nil {
-			fmt.Printf("FAILED\n")
+		schema.Indexes = append(schema.Indexes, idx)
 
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.queryRows(ctx, `
+		SELECT viewname, definition FROM pg_views
+		WHERE schemaname = current_schema()
+		ORDER BY viewname
+	`, func(rows *sql.Rows) error {
+		var (
+			v          simplemigrate.View
+			definition string
+		)
+
+		if err := rows.Scan(&v.Name, &definition); err != nil {
 			return err
 		}
 
-		fmt.Printf("OK\n")
+		v.DDL = "CREATE VIEW " + v.Name + " AS\n" + strings.TrimSpace(definition)
+
+		schema.Views = append(schema.Views, v)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &schema, nil
 }
 
-func (d *driver) applyOne(ctx context.Context, insertQ string, tx *sql.Tx, m simplemigrate.Migration) error {
-	trans, rollback, commit, err := d.createTxIfNotExists(ctx, tx)
+// queryRows runs query and calls fn for every row
+func (d *driver) queryRows(ctx context.Context, query string, fn func(*sql.Rows) error) error {
+	rows, err := d.DB().QueryContext(ctx, query)
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		_ = rollback()
-	}()
+	defer rows.Close()
 
-	for _, query := range m.Statements {
-		_, err = trans.ExecContext(ctx, query)
-		if err != nil {
+	for rows.Next() {
+		if err := fn(rows); err != nil {
 			return err
 		}
 	}
 
-	_, err = trans.ExecContext(ctx, insertQ, m.Version, m.Fname, m.Hash, time.Now().UTC())
-	if err != nil {
-		return err
+	return rows.Err()
+}
+
+// unqualify removes the schema qualifier that pg_get_indexdef adds to table names
+func unqualify(ddl, schemaName string) string {
+	return strings.ReplaceAll(ddl, " ON "+schemaName+".", " ON ")
+}
+
+// Scratch creates an empty schema on a dedicated connection and returns
+// a driver that uses it as search_path
+// The returned function drops the schema and releases the connection
+func (d *driver) Scratch(ctx context.Context) (simplemigrate.DBDriver, func(context.Context) error, error) {
+	db, ok := d.DB().(*sql.DB)
+	if !ok {
+		return nil, nil, errors.New("cannot create a scratch schema from a scratch driver")
 	}
 
-	err = commit()
+	c, err := db.Conn(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	return nil
-}
+	name := fmt.Sprintf("simplemigrate_scratch_%d", time.Now().UnixNano())
+
+	_, err = c.ExecContext(ctx, "CREATE SCHEMA "+name+"; SET search_path TO "+name)
+	if err != nil {
+		_ = c.Close()
 
-//nolint:gocritic // TODO: refactor
-func (d *driver) createTxIfNotExists(
-	ctx context.Context,
-	tx *sql.Tx,
-) (*sql.Tx, func() error, func() error, error) {
-	if tx != nil {
-		return tx, func() error { return nil }, func() error { return nil }, nil
+		return nil, nil, err
 	}
 
-	tx, err := d.db.BeginTx(ctx, nil)
-	if err != nil {
-		return nil, nil, nil, err
+	cleanup := func(ctx context.Context) error {
+		defer c.Close()
+
+		_, err := c.ExecContext(ctx, "DROP SCHEMA "+name+" CASCADE; RESET search_path")
+
+		return err
 	}
 
-	return tx, tx.Rollback, tx.Commit, nil
+	return &driver{Driver: sqldriver.New(c, Dialect)}, cleanup, nil
 }
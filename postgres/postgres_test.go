@@ -0,0 +1,17 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate/postgres"
+)
+
+func TestDialect_QuoteIdentifier(t *testing.T) {
+	t.Parallel()
+
+	// mixed case names keep using the table that postgres created for them unquoted
+	require.Equal(t, `"schemamigrations"`, postgres.Dialect.QuoteIdentifier("SchemaMigrations"))
+	require.Equal(t, `"schema_migrations"`, postgres.Dialect.QuoteIdentifier("schema_migrations"))
+}
@@ -0,0 +1,204 @@
+package simplemigrate
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress describes the progress of a Migrate run
+// It is reported when a migration starts, when a statement starts,
+// when a failed attempt is retried and when a migration finishes
+type Progress struct {
+	// Index is the 1-based index of the current migration
+	Index int
+	// Total is the number of migrations that are applied in this run
+	Total int
+	// Version is the version of the current migration
+	Version int
+	// Fname is the file name of the current migration
+	Fname string
+	// Statement is the 1-based index of the current statement
+	// It is 0 when the migration starts, is retried or has finished
+	Statement int
+	// Statements is the number of statements of the current migration
+	Statements int
+	// Attempt is the 1-based attempt of the current migration
+	// It is greater than 1 when the migration is retried (see WithRetry)
+	Attempt int
+	// Retry is true when the attempt failed with Err and the migration
+	// is attempted again after Backoff
+	Retry bool
+	// Backoff is the time to wait before the next attempt if Retry is true
+	Backoff time.Duration
+	// Done is true when the current migration has finished
+	Done bool
+	// Err is the error of the current migration if it has failed
+	// or of the attempt if Retry is true
+	Err error
+	// Duration is how long the current migration took
+	// It is only set when the migration is Done
+	Duration time.Duration
+	// Elapsed is the time elapsed since the first migration started
+	Elapsed time.Duration
+	// ETA is the estimated remaining time (0 if it cannot be estimated)
+	// It is based on the execution time of the migrations that have already
+	// been applied (recorded in the migrations table) and of this run
+	ETA time.Duration
+}
+
+// WithProgress is an option to report the progress of the migrations
+// fn is called synchronously, so it should return quickly
+// It is disabled by default
+func WithProgress(fn func(Progress)) Option {
+	return func(m *Migrator) error {
+		m.progress = fn
+
+		return nil
+	}
+}
+
+// progressTracker computes the progress of a Migrate run
+type progressTracker struct {
+	mu           sync.Mutex
+	fn           func(Progress)
+	total        int
+	firstVersion int
+	start        time.Time
+	current      time.Time
+	attempt      int
+	completed    int
+	samples      int
+	samplesSum   time.Duration
+}
+
+// newProgressTracker creates a tracker for applying toApply
+// The execution times of the applied migrations are used to estimate the ETA
+func newProgressTracker(fn func(Progress), applied, toApply []Migration) *progressTracker {
+	if fn == nil || len(toApply) == 0 {
+		return nil
+	}
+
+	ans := progressTracker{
+		fn:           fn,
+		total:        len(toApply),
+		firstVersion: toApply[0].Version,
+	}
+
+	for i := range applied {
+		if applied[i].ExecutionTime > 0 {
+			ans.samples++
+			ans.samplesSum += applied[i].ExecutionTime
+		}
+	}
+
+	return &ans
+}
+
+func (p *progressTracker) migrationStarted(m Migration, attempt int) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+
+	now := time.Now()
+
+	if p.start.IsZero() {
+		p.start = now
+	}
+
+	p.current = now
+	p.attempt = attempt
+
+	progress := p.progress(m)
+	p.mu.Unlock()
+
+	p.fn(progress)
+}
+
+func (p *progressTracker) statementStarted(m Migration, idx int) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	progress := p.progress(m)
+	p.mu.Unlock()
+
+	progress.Statement = idx + 1
+
+	p.fn(progress)
+}
+
+// migrationRetried reports that the attempt of m failed with err
+// and that m is attempted again after backoff
+func (p *progressTracker) migrationRetried(m Migration, err error, backoff time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	progress := p.progress(m)
+	p.mu.Unlock()
+
+	progress.Retry = true
+	progress.Err = err
+	progress.Backoff = backoff
+
+	p.fn(progress)
+}
+
+func (p *progressTracker) migrationFinished(m Migration, err error) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+
+	duration := time.Since(p.current)
+
+	if err == nil {
+		p.completed++
+		p.samples++
+		p.samplesSum += duration
+	}
+
+	progress := p.progress(m)
+	p.mu.Unlock()
+
+	progress.Done = true
+	progress.Err = err
+	progress.Duration = duration
+
+	p.fn(progress)
+}
+
+// progress must be called with mu held
+func (p *progressTracker) progress(m Migration) Progress {
+	now := time.Now()
+
+	ans := Progress{
+		Index:      m.Version - p.firstVersion + 1,
+		Total:      p.total,
+		Version:    m.Version,
+		Fname:      m.Fname,
+		Statements: len(m.Statements),
+		Attempt:    p.attempt,
+		Elapsed:    now.Sub(p.start),
+	}
+
+	if p.samples > 0 {
+		avg := p.samplesSum / time.Duration(p.samples)
+		remaining := time.Duration(p.total-p.completed) * avg
+
+		if p.completed < p.total {
+			remaining -= now.Sub(p.current)
+		}
+
+		if remaining > 0 {
+			ans.ETA = remaining
+		}
+	}
+
+	return ans
+}
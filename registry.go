@@ -0,0 +1,75 @@
+package simplemigrate
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DriverFactory creates a driver from a connection url
+// It receives the url passed to Open unchanged
+type DriverFactory func(ctx context.Context, connURL string) (DBDriver, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]DriverFactory{}
+)
+
+// RegisterDriver makes a driver available by the scheme of its connection urls
+// It is usually called from the init function of the package of the driver
+// It panics if it is called twice for the same scheme or if factory is nil
+func RegisterDriver(scheme string, factory DriverFactory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if factory == nil {
+		panic("simplemigrate: RegisterDriver factory is nil")
+	}
+
+	scheme = strings.ToLower(scheme)
+
+	if _, dup := drivers[scheme]; dup {
+		panic("simplemigrate: RegisterDriver called twice for scheme " + scheme)
+	}
+
+	drivers[scheme] = factory
+}
+
+// Drivers returns a sorted list of the registered schemes
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// Open creates a driver with the factory registered for the scheme of connURL
+// The package of the driver must be imported, e.g.:
+//
+//	import _ "github.com/gosom/simplemigrate/postgres"
+func Open(ctx context.Context, connURL string) (DBDriver, error) {
+	u, err := url.Parse(connURL)
+	if err != nil {
+		return nil, err
+	}
+
+	driversMu.RLock()
+	factory, ok := drivers[strings.ToLower(u.Scheme)]
+	driversMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, u.Scheme)
+	}
+
+	return factory(ctx, connURL)
+}
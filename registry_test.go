@@ -0,0 +1,47 @@
+package simplemigrate_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/memdriver"
+)
+
+func Test_Registry(t *testing.T) {
+	t.Parallel()
+
+	var got string
+
+	simplemigrate.RegisterDriver("registrytest", func(_ context.Context, connURL string) (simplemigrate.DBDriver, error) {
+		got = connURL
+
+		return memdriver.New(), nil
+	})
+
+	t.Run("should open a driver by the scheme of the url", func(t *testing.T) {
+		driver, err := simplemigrate.Open(context.Background(), "RegistryTest://host/db")
+		require.NoError(t, err)
+		require.NotNil(t, driver)
+		require.Equal(t, "RegistryTest://host/db", got)
+	})
+
+	t.Run("should list the registered schemes", func(t *testing.T) {
+		require.Contains(t, simplemigrate.Drivers(), "registrytest")
+	})
+
+	t.Run("should return ErrUnknownDriver for unknown schemes", func(t *testing.T) {
+		_, err := simplemigrate.Open(context.Background(), "unknown://host/db")
+		require.ErrorIs(t, err, simplemigrate.ErrUnknownDriver)
+	})
+
+	t.Run("should panic when a scheme is registered twice", func(t *testing.T) {
+		require.Panics(t, func() {
+			simplemigrate.RegisterDriver("registrytest", func(context.Context, string) (simplemigrate.DBDriver, error) {
+				return memdriver.New(), nil
+			})
+		})
+	})
+}
@@ -0,0 +1,95 @@
+package simplemigrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Rename moves a migration file to a new version
+type Rename struct {
+	From    string
+	To      string
+	Version int
+}
+
+// Renumber returns the renames that move the local migration files that
+// collide with keep to the next free versions
+// keep are the names of the files that must keep their versions, e.g. the
+// applied migrations or the files of the main branch; they are never renamed
+// A file that is not kept collides if its version is not after the last kept
+// version, or after the new version of the file before it, so the files keep
+// their order and the ones that do not collide are not renamed
+func (m *Migrator) Renumber(ctx context.Context, keep []string) ([]Rename, error) {
+	_, span := m.tracer.Start(ctx, "simplemigrate.Renumber")
+	defer span.End()
+
+	files, err := listFiles(m.folder, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make(map[string]bool, len(keep))
+	last := 0
+
+	for _, fname := range keep {
+		version, _, err := parseFname(fname)
+		if err != nil {
+			return nil, err
+		}
+
+		kept[fname] = true
+		last = max(last, version)
+	}
+
+	type localFile struct {
+		fname   string
+		version int
+		name    string
+	}
+
+	var movable []localFile
+
+	for _, fname := range files {
+		version, name, err := parseFname(fname)
+		if err != nil {
+			return nil, err
+		}
+
+		if kept[fname] {
+			last = max(last, version)
+
+			continue
+		}
+
+		movable = append(movable, localFile{fname: fname, version: version, name: name})
+	}
+
+	sort.Slice(movable, func(i, j int) bool {
+		if movable[i].version != movable[j].version {
+			return movable[i].version < movable[j].version
+		}
+
+		return movable[i].fname < movable[j].fname
+	})
+
+	var renames []Rename
+
+	for _, f := range movable {
+		if f.version > last {
+			last = f.version
+
+			continue
+		}
+
+		last++
+
+		renames = append(renames, Rename{
+			From:    f.fname,
+			To:      fmt.Sprintf("%d_%s", last, f.name),
+			Version: last,
+		})
+	}
+
+	return renames, nil
+}
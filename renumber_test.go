@@ -0,0 +1,83 @@
+package simplemigrate_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/memdriver"
+)
+
+func Test_Renumber(t *testing.T) {
+	t.Parallel()
+
+	// both branches added a 3_ and this one also a 4_
+	folder := fstest.MapFS{
+		"1_a.sql":      {Data: []byte("CREATE TABLE a (id INT);")},
+		"2_b.sql":      {Data: []byte("CREATE TABLE b (id INT);")},
+		"3_theirs.sql": {Data: []byte("CREATE TABLE theirs (id INT);")},
+		"3_ours.sql":   {Data: []byte("CREATE TABLE ours (id INT);")},
+		"4_ours_2.sql": {Data: []byte("CREATE TABLE ours_2 (id INT);")},
+		"10_later.sql": {Data: []byte("CREATE TABLE later (id INT);")},
+	}
+
+	m := simplemigrate.New(memdriver.New(), simplemigrate.WithEmbedFS(folder))
+
+	renames, err := m.Renumber(context.Background(), []string{"1_a.sql", "2_b.sql", "3_theirs.sql"})
+	require.NoError(t, err)
+	// 4_ours_2.sql collides with the new version of 3_ours.sql, 10_later.sql does not collide
+	require.Equal(t, []simplemigrate.Rename{
+		{From: "3_ours.sql", To: "4_ours.sql", Version: 4},
+		{From: "4_ours_2.sql", To: "5_ours_2.sql", Version: 5},
+	}, renames)
+
+	t.Run("should keep files that are not local", func(t *testing.T) {
+		t.Parallel()
+
+		// the other branch added 3_theirs.sql and 4_more.sql, that are not merged yet
+		renames, err := m.Renumber(context.Background(), []string{"1_a.sql", "2_b.sql", "3_theirs.sql", "4_more.sql"})
+		require.NoError(t, err)
+		require.Len(t, renames, 2)
+		require.Equal(t, "5_ours.sql", renames[0].To)
+		require.Equal(t, "6_ours_2.sql", renames[1].To)
+	})
+
+	t.Run("should only move the files that collide", func(t *testing.T) {
+		t.Parallel()
+
+		m := simplemigrate.New(memdriver.New(), simplemigrate.WithEmbedFS(fstest.MapFS{
+			"1_a.sql":      {Data: []byte("CREATE TABLE a (id INT);")},
+			"2_ours.sql":   {Data: []byte("CREATE TABLE ours (id INT);")},
+			"4_ours_2.sql": {Data: []byte("CREATE TABLE ours_2 (id INT);")},
+		}))
+
+		renames, err := m.Renumber(context.Background(), []string{"1_a.sql", "2_theirs.sql"})
+		require.NoError(t, err)
+		require.Equal(t, []simplemigrate.Rename{
+			{From: "2_ours.sql", To: "3_ours.sql", Version: 3},
+		}, renames)
+	})
+
+	t.Run("should return nothing when there is no conflict", func(t *testing.T) {
+		t.Parallel()
+
+		m := simplemigrate.New(memdriver.New(), simplemigrate.WithEmbedFS(fstest.MapFS{
+			"1_a.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+			"2_b.sql": {Data: []byte("CREATE TABLE b (id INT);")},
+		}))
+
+		renames, err := m.Renumber(context.Background(), []string{"1_a.sql"})
+		require.NoError(t, err)
+		require.Empty(t, renames)
+	})
+
+	t.Run("should fail on invalid names", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := m.Renumber(context.Background(), []string{"a.sql"})
+		require.ErrorIs(t, err, simplemigrate.ErrInvalidMigrationFile)
+	})
+}
@@ -0,0 +1,119 @@
+package simplemigrate
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInvalidRetryPolicy is returned when the retry policy is invalid
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+// RetryClassifier is an optional interface implemented by drivers
+// that can tell transient errors (e.g. lock contention or serialization
+// failures) apart from permanent ones
+type RetryClassifier interface {
+	// IsRetryable returns true if the migration that failed with err can be retried
+	IsRetryable(err error) bool
+}
+
+// RetryPolicy configures how migrations that fail with a transient error are retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts (including the first one)
+	MaxAttempts int
+	// InitialBackoff is the time to wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the time to wait between retries
+	// The backoff doubles after every retry
+	MaxBackoff time.Duration
+}
+
+// WithRetry is an option to retry migrations that fail with a transient error
+// A migration file is retried only when it runs in its own transaction
+// (so not with WithInTransaction or a driver without transactional DDL,
+// whose failed file may be partly applied) and the driver implements RetryClassifier
+// It is disabled by default
+func WithRetry(policy RetryPolicy) Option {
+	return func(m *Migrator) error {
+		if policy.MaxAttempts < 1 || policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+			return ErrInvalidRetryPolicy
+		}
+
+		m.retry = policy
+
+		return nil
+	}
+}
+
+// applyMigrations applies the migrations using the driver
+// retrying them according to the retry policy
+func (m *Migrator) applyMigrations(ctx context.Context, migrations []Migration) error {
+	classifier, ok := m.driver.(RetryClassifier)
+	if !ok || m.inTransaction || m.retry.MaxAttempts <= 1 || !supportsTransactionalDDL(m.driver) {
+		return m.driver.ApplyMigrations(ctx, m.migrationsTable, m.inTransaction, migrations)
+	}
+
+	for _, migration := range migrations {
+		if err := m.applyWithRetry(ctx, classifier, migration); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyWithRetry applies a single migration in its own transaction retrying it on transient errors
+func (m *Migrator) applyWithRetry(ctx context.Context, classifier RetryClassifier, migration Migration) error {
+	for attempt := 1; ; attempt++ {
+		err := m.driver.ApplyMigrations(context.WithValue(ctx, attemptKey{}, attempt),
+			m.migrationsTable, false, []Migration{migration})
+		if err == nil {
+			return nil
+		}
+
+		backoff, ok := m.retryBackoff(classifier, err, attempt)
+		if !ok {
+			return err
+		}
+
+		m.printer("%s: attempt %d/%d failed: %v (retrying in %s)\n",
+			migration.Fname, attempt, m.retry.MaxAttempts, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// retryBackoff returns the time to wait before retrying a migration whose
+// attempt failed with err and false if it is not retried
+func (m *Migrator) retryBackoff(classifier RetryClassifier, err error, attempt int) (time.Duration, bool) {
+	if attempt >= m.retry.MaxAttempts || !classifier.IsRetryable(err) {
+		return 0, false
+	}
+
+	// the backoff doubles after every retry
+	backoff := m.retry.InitialBackoff
+
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if m.retry.MaxBackoff > 0 && backoff > m.retry.MaxBackoff {
+			return m.retry.MaxBackoff, true
+		}
+	}
+
+	return backoff, true
+}
+
+// attemptKey carries the attempt of the migration applied by applyWithRetry
+type attemptKey struct{}
+
+// attemptFromContext returns the attempt carried by ctx and false
+// if the migration is not retried
+func attemptFromContext(ctx context.Context) (int, bool) {
+	attempt, ok := ctx.Value(attemptKey{}).(int)
+
+	return attempt, ok
+}
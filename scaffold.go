@@ -0,0 +1,64 @@
+package simplemigrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// migrationTemplate is the content of a new migration file
+// The directives are indented so that they are documented but not active
+const migrationTemplate = `-- %s
+--
+-- Directives, each on its own line that starts with "-- ":
+--   migrate:timeout 30s     overrides the timeout of this migration
+--   migrate:next            separates the statements of this file
+--   migrate:allow <rule>    allows a statement the linter flags (e.g. drop-column)
+
+`
+
+// ScaffoldMigration returns the file name and the content of the next
+// migration, named after name
+// The folder is read like in Migrate, so it fails if it is invalid
+func (m *Migrator) ScaffoldMigration(ctx context.Context, name string) (string, []byte, error) {
+	slug := slugify(name)
+	if slug == "" {
+		return "", nil, fmt.Errorf("%w: %q is not a valid migration name", ErrInvalidMigrationFile, name)
+	}
+
+	migrations, err := m.readMigrations(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	fname := fmt.Sprintf("%d_%s.sql", len(migrations)+1, slug)
+
+	return fname, []byte(fmt.Sprintf(migrationTemplate, fname)), nil
+}
+
+// slugify lowercases name and replaces every run of characters that are
+// not letters or digits with an underscore
+func slugify(name string) string {
+	var sb strings.Builder
+
+	pending := false
+
+	for _, r := range strings.ToLower(name) {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			pending = sb.Len() > 0
+
+			continue
+		}
+
+		if pending {
+			sb.WriteByte('_')
+
+			pending = false
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
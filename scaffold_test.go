@@ -0,0 +1,48 @@
+package simplemigrate_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/memdriver"
+)
+
+func Test_ScaffoldMigration(t *testing.T) {
+	t.Parallel()
+
+	folder := fstest.MapFS{
+		"1_a.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+		"2_b.sql": {Data: []byte("CREATE TABLE b (id INT);")},
+	}
+
+	m := simplemigrate.New(memdriver.New(), simplemigrate.WithEmbedFS(folder))
+
+	fname, content, err := m.ScaffoldMigration(context.Background(), " Add users.email -- NOT NULL ")
+	require.NoError(t, err)
+	require.Equal(t, "3_add_users_email_not_null.sql", fname)
+	require.Contains(t, string(content), "migrate:timeout")
+
+	// the scaffolded file is a valid migration
+	folder[fname] = &fstest.MapFile{Data: append(content, "CREATE TABLE c (id INT);"...)}
+
+	migrations, err := m.Validate(context.Background())
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	require.Len(t, migrations[2].Statements, 1)
+	require.Zero(t, migrations[2].Timeout)
+
+	_, _, err = m.ScaffoldMigration(context.Background(), "---")
+	require.ErrorIs(t, err, simplemigrate.ErrInvalidMigrationFile)
+
+	invalid := simplemigrate.New(memdriver.New(), simplemigrate.WithEmbedFS(fstest.MapFS{
+		"1_a.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+		"3_c.sql": {Data: []byte("CREATE TABLE c (id INT);")},
+	}))
+
+	_, _, err = invalid.ScaffoldMigration(context.Background(), "add_users_email")
+	require.ErrorIs(t, err, simplemigrate.ErrInvalidMigrationFile)
+}
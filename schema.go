@@ -0,0 +1,211 @@
+package simplemigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrSchemaDumpUnsupported is returned when the driver cannot dump the schema
+var ErrSchemaDumpUnsupported = errors.New("driver does not support schema dumps")
+
+// SchemaDumper is an optional interface implemented by drivers
+// that can introspect the schema of the database
+type SchemaDumper interface {
+	// DumpSchema returns the schema of the database
+	DumpSchema(ctx context.Context) (*Schema, error)
+}
+
+// Schema represents the schema of a database
+type Schema struct {
+	Dialect string
+	Tables  []Table
+	Indexes []Index
+	Views   []View
+}
+
+// Table represents a table of the schema
+type Table struct {
+	Name        string
+	Columns     []Column
+	Constraints []Constraint
+	// DDL is the statement that creates the table
+	// If it is empty it is generated from the columns and the constraints
+	DDL string
+}
+
+// Column represents a column of a table
+type Column struct {
+	Name    string
+	Type    string
+	NotNull bool
+	Default string
+}
+
+// Constraint represents a table constraint (primary key, foreign key, unique or check)
+type Constraint struct {
+	Name       string
+	Definition string
+}
+
+// Index represents an index that is not backing a constraint
+type Index struct {
+	Name  string
+	Table string
+	// DDL is the statement that creates the index
+	DDL string
+}
+
+// View represents a view
+type View struct {
+	Name string
+	// DDL is the statement that creates the view
+	DDL string
+}
+
+// Sort sorts the schema objects by name so that dumps are deterministic
+// The columns keep their order
+func (s *Schema) Sort() {
+	sort.Slice(s.Tables, func(i, j int) bool {
+		return s.Tables[i].Name < s.Tables[j].Name
+	})
+
+	for i := range s.Tables {
+		constraints := s.Tables[i].Constraints
+
+		sort.Slice(constraints, func(i, j int) bool {
+			return constraints[i].Name < constraints[j].Name
+		})
+	}
+
+	sort.Slice(s.Indexes, func(i, j int) bool {
+		if s.Indexes[i].Table != s.Indexes[j].Table {
+			return s.Indexes[i].Table < s.Indexes[j].Table
+		}
+
+		return s.Indexes[i].Name < s.Indexes[j].Name
+	})
+
+	sort.Slice(s.Views, func(i, j int) bool {
+		return s.Views[i].Name < s.Views[j].Name
+	})
+}
+
+// DDL returns the statements that create the schema
+// Tables come first, followed by indexes and views, each sorted by name
+func (s *Schema) DDL() string {
+	s.Sort()
+
+	var b strings.Builder
+
+	b.WriteString("-- Schema dumped by simplemigrate. DO NOT EDIT.\n")
+	b.WriteString("-- dialect: " + s.Dialect + "\n")
+
+	for i := range s.Tables {
+		writeStatement(&b, s.Tables[i].createStatement())
+	}
+
+	for i := range s.Indexes {
+		writeStatement(&b, s.Indexes[i].DDL)
+	}
+
+	for i := range s.Views {
+		writeStatement(&b, s.Views[i].DDL)
+	}
+
+	return b.String()
+}
+
+func (t *Table) createStatement() string {
+	if t.DDL != "" {
+		return t.DDL
+	}
+
+	lines := make([]string, 0, len(t.Columns)+len(t.Constraints))
+
+	for _, c := range t.Columns {
+		line := quoteIdentifier(c.Name) + " " + c.Type
+
+		if c.NotNull {
+			line += " NOT NULL"
+		}
+
+		if c.Default != "" {
+			line += " DEFAULT " + c.Default
+		}
+
+		lines = append(lines, line)
+	}
+
+	for _, c := range t.Constraints {
+		lines = append(lines, "CONSTRAINT "+quoteIdentifier(c.Name)+" "+c.Definition)
+	}
+
+	return "CREATE TABLE " + quoteIdentifier(t.Name) + " (\n    " + strings.Join(lines, ",\n    ") + "\n)"
+}
+
+func writeStatement(b *strings.Builder, stmt string) {
+	b.WriteString("\n")
+	b.WriteString(strings.TrimRight(strings.TrimSpace(stmt), ";"))
+	b.WriteString(";\n")
+}
+
+// quoteIdentifier quotes name with double quotes unless it is a lower case identifier
+func quoteIdentifier(name string) string {
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+
+	return name
+}
+
+// WithSchemaDump is an option to write the schema to path after migrating
+// The driver must implement SchemaDumper
+// It is disabled by default
+func WithSchemaDump(path string) Option {
+	return func(m *Migrator) error {
+		m.schemaDumpPath = path
+
+		return nil
+	}
+}
+
+// DumpSchema returns the current schema of the database
+// It returns ErrSchemaDumpUnsupported if the driver does not implement SchemaDumper
+func (m *Migrator) DumpSchema(ctx context.Context) (*Schema, error) {
+	dumper, ok := m.driver.(SchemaDumper)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSchemaDumpUnsupported, m.driver.Dialect())
+	}
+
+	schema, err := dumper.DumpSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.Sort()
+
+	return schema, nil
+}
+
+// writeSchemaDump writes the schema to the path set with WithSchemaDump
+func (m *Migrator) writeSchemaDump(ctx context.Context) error {
+	if m.schemaDumpPath == "" {
+		return nil
+	}
+
+	schema, err := m.DumpSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	//nolint:gosec // the schema dump is meant to be committed and read by others
+	return os.WriteFile(m.schemaDumpPath, []byte(schema.DDL()), 0o644)
+}
@@ -26,11 +26,21 @@ var (
 	ErrMigrationFolder = errors.New("invalid migration folder")
 	// ErrInvalidQuery is returned when the query is invalid
 	ErrInvalidQuery = errors.New("invalid query")
+	// ErrOutOfSync is returned when the applied migrations do not match the
+	// local files, e.g. a file changed after it was applied
+	// It is returned together with ErrInvalidMigrationFile
+	ErrOutOfSync = errors.New("local migrations are not in sync with applied migrations")
+	// ErrMigrationFailed is returned by Migrate when the database fails to apply a migration
+	ErrMigrationFailed = errors.New("migration failed")
 )
 
 const (
 	// defaultMigrationsTable is the default name of the migrations table
 	defaultMigrationsTable = "schema_migrations"
+	// statementSeparator separates the statements of a migration file
+	statementSeparator = "-- migrate:next"
+	// timeoutDirective sets the timeout of a migration file
+	timeoutDirective = "-- migrate:timeout"
 )
 
 // Migration represents a single migration
@@ -40,6 +50,22 @@ type Migration struct {
 	AppliedAt  *time.Time
 	Statements []string
 	Hash       string
+	// Timeout is the timeout of the migration set with
+	// the "-- migrate:timeout" directive (0 if not set)
+	Timeout time.Duration
+	// ExecutionTime is how long it took to apply the migration
+	// (0 if the driver does not record it)
+	ExecutionTime time.Duration
+	// AppliedBy is the user that applied the migration
+	AppliedBy string
+	// Hostname is the host the migration was applied from
+	Hostname string
+	// AppVersion is the version of the application that applied the migration
+	AppVersion string
+	// ToolVersion is the version of simplemigrate that applied the migration
+	ToolVersion string
+	// Dirty is true if the migration started but did not finish
+	Dirty bool
 }
 
 // DBDriver represents a database driver
@@ -80,12 +106,23 @@ type Option func(*Migrator) error
 // Migrator is a struct that represents a migrator
 // It is used to migrate a database
 type Migrator struct {
-	driver          DBDriver
-	migrationsTable string
-	printer         func(string, ...any)
-	folder          fs.FS
-	qvalidator      QueryValidator
-	inTransaction   bool
+	driver           DBDriver
+	migrationsTable  string
+	printer          func(format string, args ...any)
+	folder           fs.FS
+	qvalidators      []QueryValidator
+	inTransaction    bool
+	metrics          Metrics
+	tracer           Tracer
+	migrationTimeout time.Duration
+	statementTimeout time.Duration
+	retry            RetryPolicy
+	progress         func(Progress)
+	schemaDumpPath   string
+	lockTimeout      time.Duration
+	appVersion       string
+	appliedBy        string
+	templateVars     map[string]string
 }
 
 // New is a constructor for Migrator
@@ -97,6 +134,9 @@ func New(driver DBDriver, opts ...Option) *Migrator {
 	ans := Migrator{
 		driver:          driver,
 		migrationsTable: defaultMigrationsTable,
+		printer:         printf,
+		metrics:         nopMetrics{},
+		tracer:          nopTracer{},
 	}
 
 	for _, opt := range opts {
@@ -126,9 +166,10 @@ func WithInTransaction() Option {
 // WithQueryValidator is an option to enable query validation
 // It is disabled by default
 // Its purpose is to validate queries before applying them
+// It can be used multiple times, the validators run in order
 func WithQueryValidator(validator QueryValidator) Option {
 	return func(m *Migrator) error {
-		m.qvalidator = validator
+		m.qvalidators = append(m.qvalidators, validator)
 
 		return nil
 	}
@@ -173,22 +214,112 @@ func WithMigrationTable(migrationsTable string) Option {
 			return ErrMigrationTableNameMissing
 		}
 
+		if err := ValidateTableName(migrationsTable); err != nil {
+			return err
+		}
+
 		m.migrationsTable = migrationsTable
 
 		return nil
 	}
 }
 
+// WithMetrics is an option to collect metrics about the migrations
+// It is disabled by default
+func WithMetrics(metrics Metrics) Option {
+	return func(m *Migrator) error {
+		m.metrics = metrics
+
+		return nil
+	}
+}
+
+// WithTracer is an option to trace the migrations
+// Spans are created for Migrate, reading the migration files,
+// validation, each migration and each statement
+// It is disabled by default
+func WithTracer(tracer Tracer) Option {
+	return func(m *Migrator) error {
+		m.tracer = tracer
+
+		return nil
+	}
+}
+
+// WithMigrationTimeout is an option to set the maximum duration of a single migration
+// A migration file can override it with a "-- migrate:timeout 30s" line
+// It is disabled by default
+func WithMigrationTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) error {
+		m.migrationTimeout = timeout
+
+		return nil
+	}
+}
+
+// WithStatementTimeout is an option to set the maximum duration of a single statement
+// It is disabled by default
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) error {
+		m.statementTimeout = timeout
+
+		return nil
+	}
+}
+
+// WithPrinter is an option to set where the messages of Migrate are written
+// Use it with a nop function to silence them
+// By default they are written to stdout
+func WithPrinter(printer func(format string, args ...any)) Option {
+	return func(m *Migrator) error {
+		if printer == nil {
+			return errors.New("printer cannot be nil")
+		}
+
+		m.printer = printer
+
+		return nil
+	}
+}
+
 // Migrate is used to apply migrations to a database
 // It returns an error if something goes wrong
-func (m *Migrator) Migrate(ctx context.Context) error {
-	fmt.Println("Migrating...")
+func (m *Migrator) Migrate(ctx context.Context) (err error) {
+	start := time.Now()
+
+	ctx, span := m.tracer.Start(ctx, "simplemigrate.Migrate",
+		Attribute{Key: AttrDialect, Value: m.driver.Dialect()},
+		Attribute{Key: AttrMigrationTable, Value: m.migrationsTable},
+	)
+
+	defer func() {
+		endSpan(span, err)
+		m.metrics.ObserveMigrateDuration(time.Since(start))
+	}()
+
+	m.printer("Migrating...\n")
+
+	if m.inTransaction && !supportsTransactionalDDL(m.driver) {
+		return fmt.Errorf("%w: %s cannot roll back DDL statements, do not use WithInTransaction",
+			ErrTransactionalDDLUnsupported, m.driver.Dialect())
+	}
+
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if uerr := unlock(ctx); uerr != nil && err == nil {
+			err = uerr
+		}
+	}()
 
 	if err := m.driver.CreateMigrationsTable(ctx, m.migrationsTable); err != nil {
 		return err
 	}
 
-	fmt.Println("Migrations table:", m.migrationsTable)
+	m.printer("Migrations table: %s\n", m.migrationsTable)
 
 	localMigrations, err := m.readMigrations(ctx)
 	if err != nil {
@@ -200,42 +331,173 @@ func (m *Migrator) Migrate(ctx context.Context) error {
 		return err
 	}
 
-	if len(localMigrations) < len(appliedMigrations) {
-		return fmt.Errorf("%w: %s", ErrInvalidMigrationFile, "local migrations are less than applied migrations")
+	if err := checkSync(localMigrations, appliedMigrations); err != nil {
+		return err
 	}
 
-	for i := range appliedMigrations {
-		if appliedMigrations[i].Version != localMigrations[i].Version {
-			return fmt.Errorf("%w: %s", ErrInvalidMigrationFile, "local migrations are not in sync with applied migrations")
-		}
-
-		if appliedMigrations[i].Hash != localMigrations[i].Hash {
-			return fmt.Errorf("%w: %s", ErrInvalidMigrationFile, "local migrations are not in sync with applied migrations")
-		}
+	if len(appliedMigrations) > 0 {
+		m.metrics.SetSchemaVersion(appliedMigrations[len(appliedMigrations)-1].Version)
 	}
 
 	toApply := localMigrations[len(appliedMigrations):]
 
 	if len(toApply) == 0 {
-		fmt.Println("No migrations to apply")
+		m.printer("No migrations to apply\n")
 
-		return nil
+		return m.writeSchemaDump(ctx)
 	}
 
-	for _, migration := range toApply {
-		if err := m.validate(ctx, migration); err != nil {
-			return err
-		}
+	if err := m.validateAll(ctx, toApply); err != nil {
+		return err
 	}
 
-	fmt.Printf("Applying %d migrations [start_version=%d end_version=%d]\n",
+	m.printer("Applying %d migrations [start_version=%d end_version=%d]\n",
 		len(toApply), toApply[0].Version, toApply[len(toApply)-1].Version)
 
-	return m.driver.ApplyMigrations(ctx, m.migrationsTable, m.inTransaction, toApply)
+	if m.inTransaction {
+		m.printer("Applying migrations in transaction\n")
+	}
+
+	progress := newProgressTracker(m.progress, appliedMigrations, toApply)
+
+	ctx = ContextWithApplyHooks(ctx, m.applyHooks(progress))
+
+	m.stampMigrations(toApply)
+
+	if err := m.applyMigrations(ctx, toApply); err != nil {
+		return fmt.Errorf("%w: %w", ErrMigrationFailed, err)
+	}
+
+	if m.inTransaction {
+		for _, migration := range toApply {
+			m.metrics.MigrationApplied(migration)
+		}
+
+		m.metrics.SetSchemaVersion(toApply[len(toApply)-1].Version)
+	}
+
+	return m.writeSchemaDump(ctx)
+}
+
+// checkSync returns an error if an applied migration is dirty or if the
+// applied migrations are not the first local migrations with the same hashes
+func checkSync(local, applied []Migration) error {
+	if err := checkDirty(applied); err != nil {
+		return err
+	}
+
+	if len(local) < len(applied) {
+		return fmt.Errorf("%w: %w: %s", ErrInvalidMigrationFile, ErrOutOfSync, "local migrations are less than applied migrations")
+	}
+
+	for i := range applied {
+		if applied[i].Version != local[i].Version {
+			return fmt.Errorf("%w: %w", ErrInvalidMigrationFile, ErrOutOfSync)
+		}
+
+		if applied[i].Hash != local[i].Hash {
+			return fmt.Errorf("%w: %w", ErrInvalidMigrationFile, ErrOutOfSync)
+		}
+	}
+
+	return nil
+}
+
+// applyHooks returns the hooks that the driver calls while applying migrations
+func (m *Migrator) applyHooks(progress *progressTracker) *ApplyHooks {
+	dialect := m.driver.Dialect()
+
+	return &ApplyHooks{
+		MigrationStart: func(ctx context.Context, migration Migration) (context.Context, func(error)) {
+			start := time.Now()
+
+			ctx, span := m.tracer.Start(ctx, "simplemigrate.Migration",
+				Attribute{Key: AttrDialect, Value: dialect},
+				Attribute{Key: AttrVersion, Value: migration.Version},
+				Attribute{Key: AttrFname, Value: migration.Fname},
+			)
+
+			ctx, cancel := withTimeout(ctx, m.migrationTimeout, migration.Timeout)
+
+			attempt, retried := attemptFromContext(ctx)
+			if !retried {
+				attempt = 1
+			}
+
+			progress.migrationStarted(migration, attempt)
+
+			return ctx, func(err error) {
+				cancel()
+				endSpan(span, err)
+
+				m.metrics.ObserveMigrationDuration(migration, time.Since(start))
+
+				var (
+					backoff time.Duration
+					retry   bool
+				)
+
+				if err != nil && retried {
+					backoff, retry = m.retryBackoff(m.driver.(RetryClassifier), err, attempt)
+				}
+
+				// only the final attempt of a retried migration is reported
+				if retry {
+					progress.migrationRetried(migration, err, backoff)
+
+					return
+				}
+
+				progress.migrationFinished(migration, err)
+
+				if err != nil {
+					m.printer("%s...FAILED\n", migration.Fname)
+
+					m.metrics.MigrationFailed(migration)
+
+					return
+				}
+
+				m.printer("%s...OK\n", migration.Fname)
+
+				// when all migrations run in a single transaction
+				// they are only applied once it is committed
+				if !m.inTransaction {
+					m.metrics.MigrationApplied(migration)
+					m.metrics.SetSchemaVersion(migration.Version)
+				}
+			}
+		},
+		StatementStart: func(ctx context.Context, migration Migration, idx int) (context.Context, func(int64, error)) {
+			ctx, span := m.tracer.Start(ctx, "simplemigrate.Statement",
+				Attribute{Key: AttrDialect, Value: dialect},
+				Attribute{Key: AttrVersion, Value: migration.Version},
+				Attribute{Key: AttrFname, Value: migration.Fname},
+				Attribute{Key: AttrStatementIndex, Value: idx},
+			)
+
+			ctx, cancel := withTimeout(ctx, m.statementTimeout)
+
+			progress.statementStarted(migration, idx)
+
+			return ctx, func(rowsAffected int64, err error) {
+				cancel()
+				span.SetAttributes(Attribute{Key: AttrRowsAffected, Value: rowsAffected})
+				endSpan(span, err)
+			}
+		},
+		StatementTimeout: m.statementTimeout,
+	}
 }
 
 // readMigrations is used to read migrations from the filesystem
-func (m *Migrator) readMigrations(_ context.Context) ([]Migration, error) {
+func (m *Migrator) readMigrations(ctx context.Context) (_ []Migration, err error) {
+	_, span := m.tracer.Start(ctx, "simplemigrate.ReadMigrations")
+
+	defer func() {
+		endSpan(span, err)
+	}()
+
 	files, err := listFiles(m.folder, ".")
 	if err != nil {
 		return nil, err
@@ -248,17 +510,9 @@ func (m *Migrator) readMigrations(_ context.Context) ([]Migration, error) {
 			Fname: file,
 		}
 
-		idx := strings.Index(file, "_")
-		if idx == -1 {
-			return nil, fmt.Errorf("%w: %s", ErrInvalidMigrationFile, file+" must have a version")
-		}
-
-		if _, err := fmt.Sscanf(file[:idx], "%d", &migration.Version); err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrInvalidMigrationFile, file+" must have an integer version")
-		}
-
-		if migration.Version == 0 {
-			return nil, fmt.Errorf("%w: %s", ErrInvalidMigrationFile, file+" must have a non-zero version")
+		migration.Version, _, err = parseFname(file)
+		if err != nil {
+			return nil, err
 		}
 
 		data, err := fs.ReadFile(m.folder, file)
@@ -270,7 +524,17 @@ func (m *Migrator) readMigrations(_ context.Context) ([]Migration, error) {
 
 		migration.Hash = computeHash(data)
 
-		statements := strings.Split(string(data), "-- migrate:next")
+		data, err = m.render(file, data)
+		if err != nil {
+			return nil, err
+		}
+
+		migration.Timeout, err = parseTimeout(file, data)
+		if err != nil {
+			return nil, err
+		}
+
+		statements := strings.Split(string(data), statementSeparator)
 
 		migration.Statements = statements
 
@@ -296,15 +560,36 @@ func (m *Migrator) readMigrations(_ context.Context) ([]Migration, error) {
 	return items, nil
 }
 
+// validateAll is used to validate the migrations that are going to be applied
+func (m *Migrator) validateAll(ctx context.Context, migrations []Migration) (err error) {
+	ctx, span := m.tracer.Start(ctx, "simplemigrate.Validate",
+		Attribute{Key: AttrCount, Value: len(migrations)},
+	)
+
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	for _, migration := range migrations {
+		if err := m.validate(ctx, migration); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // validate is used to validate a migration
 func (m *Migrator) validate(ctx context.Context, migration Migration) error {
 	if len(migration.Statements) == 0 {
 		return fmt.Errorf("%w: %s", ErrInvalidMigrationFile, migration.Fname+" is empty")
 	}
 
-	if m.qvalidator != nil {
+	ctx = ContextWithMigration(ctx, migration)
+
+	for _, qvalidator := range m.qvalidators {
 		for _, statement := range migration.Statements {
-			if err := m.qvalidator.ValidateQuery(ctx, m.driver.Dialect(), statement); err != nil {
+			if err := qvalidator.ValidateQuery(ctx, m.driver.Dialect(), statement); err != nil {
 				return fmt.Errorf("%s: %w %s", migration.Fname, ErrInvalidQuery, err)
 			}
 		}
@@ -313,6 +598,21 @@ func (m *Migrator) validate(ctx context.Context, migration Migration) error {
 	return nil
 }
 
+type migrationKey struct{}
+
+// ContextWithMigration returns a copy of ctx that carries the migration
+// The Migrator uses it to give query validators access to the whole migration file
+func ContextWithMigration(ctx context.Context, migration Migration) context.Context {
+	return context.WithValue(ctx, migrationKey{}, migration)
+}
+
+// MigrationFromContext returns the migration carried by ctx
+func MigrationFromContext(ctx context.Context) (Migration, bool) {
+	migration, ok := ctx.Value(migrationKey{}).(Migration)
+
+	return migration, ok
+}
+
 // listFiles is used to list files from the filesystem
 func listFiles(fsys fs.FS, dir string) ([]string, error) {
 	var files []string //nolint:prealloc // I don't know how many files are in the folder
@@ -337,6 +637,65 @@ func listFiles(fsys fs.FS, dir string) ([]string, error) {
 	return files, nil
 }
 
+// parseFname splits the name of a migration file into its version
+// and the rest of the name after the underscore
+func parseFname(file string) (int, string, error) {
+	idx := strings.Index(file, "_")
+	if idx == -1 {
+		return 0, "", fmt.Errorf("%w: %s", ErrInvalidMigrationFile, file+" must have a version")
+	}
+
+	var version int
+
+	if _, err := fmt.Sscanf(file[:idx], "%d", &version); err != nil {
+		return 0, "", fmt.Errorf("%w: %s", ErrInvalidMigrationFile, file+" must have an integer version")
+	}
+
+	if version == 0 {
+		return 0, "", fmt.Errorf("%w: %s", ErrInvalidMigrationFile, file+" must have a non-zero version")
+	}
+
+	return version, file[idx+1:], nil
+}
+
+// parseTimeout parses the "-- migrate:timeout" directive of a migration file
+func parseTimeout(fname string, data []byte) (time.Duration, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if !strings.HasPrefix(line, timeoutDirective) {
+			continue
+		}
+
+		timeout, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(line, timeoutDirective)))
+		if err != nil || timeout <= 0 {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidMigrationFile, fname+" has an invalid timeout")
+		}
+
+		return timeout, nil
+	}
+
+	return 0, nil
+}
+
+// withTimeout returns a child context of ctx with the last non-zero timeout
+// If all timeouts are zero ctx is returned unchanged
+func withTimeout(ctx context.Context, timeouts ...time.Duration) (context.Context, context.CancelFunc) {
+	var timeout time.Duration
+
+	for _, t := range timeouts {
+		if t > 0 {
+			timeout = t
+		}
+	}
+
+	if timeout == 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
 func isDir(path string) (bool, error) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
@@ -355,3 +714,8 @@ func computeHash(b []byte) string {
 
 	return fmt.Sprintf("%x", hash)
 }
+
+// printf writes the messages of the migrator to stdout
+func printf(format string, args ...any) {
+	fmt.Printf(format, args...)
+}
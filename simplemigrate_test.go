@@ -5,13 +5,22 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 
 	"github.com/gosom/simplemigrate"
 	"github.com/gosom/simplemigrate/internal/mocks"
+	"github.com/gosom/simplemigrate/memdriver"
+	"github.com/gosom/simplemigrate/metrics"
+	"github.com/gosom/simplemigrate/sqldriver"
+	"github.com/gosom/simplemigrate/sqlite"
 )
 
 func Test_New(t *testing.T) {
@@ -42,6 +51,18 @@ func Test_New(t *testing.T) {
 		})
 	})
 
+	t.Run("should panic when MigrationTable is not a valid identifier", func(t *testing.T) {
+		t.Parallel()
+
+		driver := &mocks.MockDBDriver{}
+
+		for _, name := range []string{"schema migrations", "a.b.c", "1migrations", "x; DROP TABLE users", `"quoted"`} {
+			require.Panics(t, func() {
+				_ = simplemigrate.New(driver, simplemigrate.WithMigrationTable(name))
+			}, name)
+		}
+	})
+
 	t.Run("should panic when folder is empty", func(t *testing.T) {
 		t.Parallel()
 
@@ -69,6 +90,7 @@ func Test_Migrate(t *testing.T) {
 		defer mctrl.Finish()
 
 		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
 
 		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
 		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
@@ -82,19 +104,37 @@ func Test_Migrate(t *testing.T) {
 			Statements: []string{stmt},
 		}
 
+		hostname, err := os.Hostname()
+		require.NoError(t, err)
+
 		driver.EXPECT().ApplyMigrations(
 			gomock.Any(),
 			tbl,
 			false,
-			[]simplemigrate.Migration{m1},
+			gomock.Len(1),
 		).
-			Return(nil)
+			DoAndReturn(func(_ context.Context, _ string, _ bool, migrations []simplemigrate.Migration) error {
+				got := migrations[0]
+				require.NotEmpty(t, got.ToolVersion)
+
+				got.ToolVersion = ""
+
+				m1.AppliedBy = "deployer"
+				m1.Hostname = hostname
+				m1.AppVersion = "v1.2.3"
+
+				require.Equal(t, m1, got)
+
+				return nil
+			})
 
 		m := simplemigrate.New(driver,
 			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithAppliedBy("deployer"),
+			simplemigrate.WithAppVersion("v1.2.3"),
 		)
 
-		err := m.Migrate(context.Background())
+		err = m.Migrate(context.Background())
 		require.NoError(t, err)
 	})
 
@@ -120,6 +160,7 @@ func Test_Migrate(t *testing.T) {
 		defer mctrl.Finish()
 
 		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
 
 		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
 		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).
@@ -140,6 +181,7 @@ func Test_Migrate(t *testing.T) {
 		defer mctrl.Finish()
 
 		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
 
 		driver.EXPECT().CreateMigrationsTable(gomock.Any(), "schema_migrations").Return(errors.New("error"))
 
@@ -148,4 +190,1010 @@ func Test_Migrate(t *testing.T) {
 		err := m.Migrate(context.Background())
 		require.Error(t, err)
 	})
+	t.Run("should collect metrics from the driver", func(t *testing.T) {
+		t.Parallel()
+
+		const tbl = "schema_migrations"
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, _ string, _ bool, migrations []simplemigrate.Migration) error {
+				for _, migration := range migrations {
+					_, done := simplemigrate.StartMigration(ctx, migration)
+					done(nil)
+				}
+
+				return nil
+			})
+
+		collector := metrics.New()
+
+		m := simplemigrate.New(driver,
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithMetrics(collector),
+		)
+
+		err := m.Migrate(context.Background())
+		require.NoError(t, err)
+
+		var out strings.Builder
+
+		_, err = collector.WriteTo(&out)
+		require.NoError(t, err)
+
+		require.Contains(t, out.String(), "simplemigrate_migrations_applied_total 1\n")
+		require.Contains(t, out.String(), "simplemigrate_migrations_failed_total 0\n")
+		require.Contains(t, out.String(), "simplemigrate_migration_duration_seconds_count 1\n")
+		require.Contains(t, out.String(), "simplemigrate_migrate_duration_seconds_count 1\n")
+		require.Contains(t, out.String(), "simplemigrate_schema_version 1\n")
+	})
+	t.Run("should create spans for the migration and its statements", func(t *testing.T) {
+		t.Parallel()
+
+		const tbl = "schema_migrations"
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, _ string, _ bool, migrations []simplemigrate.Migration) error {
+				for _, migration := range migrations {
+					mctx, done := simplemigrate.StartMigration(ctx, migration)
+
+					for i := range migration.Statements {
+						_, stmtDone := simplemigrate.StartStatement(mctx, migration, i)
+						stmtDone(1, nil)
+					}
+
+					done(nil)
+				}
+
+				return nil
+			})
+
+		tracer := &recordingTracer{}
+
+		m := simplemigrate.New(driver,
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithTracer(tracer),
+		)
+
+		err := m.Migrate(context.Background())
+		require.NoError(t, err)
+
+		require.Equal(t, []string{
+			"simplemigrate.Migrate",
+			"simplemigrate.ReadMigrations",
+			"simplemigrate.Validate",
+			"simplemigrate.Migration",
+			"simplemigrate.Statement",
+		}, tracer.names())
+
+		stmt := tracer.spans[4]
+		require.Equal(t, "simplemigrate.Migration", stmt.parent)
+		require.Contains(t, stmt.attrs, simplemigrate.Attribute{Key: simplemigrate.AttrRowsAffected, Value: int64(1)})
+		require.Contains(t, stmt.attrs, simplemigrate.Attribute{Key: simplemigrate.AttrFname, Value: "1_demo.sql"})
+
+		for _, s := range tracer.spans {
+			require.True(t, s.ended, s.name)
+		}
+	})
+}
+
+func Test_Migrate_Timeouts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should derive contexts with the migration and statement timeouts", func(t *testing.T) {
+		t.Parallel()
+
+		const tbl = "schema_migrations"
+
+		folder := fstest.MapFS{
+			"1_demo.sql": {Data: []byte("-- migrate:timeout 1h\nCREATE TABLE demo (id INT NOT NULL);")},
+		}
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, _ string, _ bool, migrations []simplemigrate.Migration) error {
+				require.Len(t, migrations, 1)
+				require.Equal(t, time.Hour, migrations[0].Timeout)
+
+				mctx, done := simplemigrate.StartMigration(ctx, migrations[0])
+				defer done(nil)
+
+				deadline, ok := mctx.Deadline()
+				require.True(t, ok)
+				require.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Minute)
+
+				sctx, stmtDone := simplemigrate.StartStatement(mctx, migrations[0], 0)
+				defer stmtDone(0, nil)
+
+				deadline, ok = sctx.Deadline()
+				require.True(t, ok)
+				require.WithinDuration(t, time.Now().Add(time.Second), deadline, time.Minute)
+
+				return nil
+			})
+
+		m := simplemigrate.New(driver,
+			simplemigrate.WithEmbedFS(folder),
+			simplemigrate.WithMigrationTimeout(time.Minute),
+			simplemigrate.WithStatementTimeout(time.Second),
+		)
+
+		err := m.Migrate(context.Background())
+		require.NoError(t, err)
+	})
+
+	t.Run("should return an error when the timeout directive is invalid", func(t *testing.T) {
+		t.Parallel()
+
+		folder := fstest.MapFS{
+			"1_demo.sql": {Data: []byte("-- migrate:timeout soon\nCREATE TABLE demo (id INT NOT NULL);")},
+		}
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), "schema_migrations").Return(nil)
+
+		m := simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder))
+
+		err := m.Migrate(context.Background())
+		require.ErrorIs(t, err, simplemigrate.ErrInvalidMigrationFile)
+	})
+}
+
+func Test_Schema_DDL(t *testing.T) {
+	t.Parallel()
+
+	schema := simplemigrate.Schema{
+		Dialect: "postgres",
+		Tables: []simplemigrate.Table{
+			{
+				Name: "users",
+				Columns: []simplemigrate.Column{
+					{Name: "id", Type: "integer", NotNull: true},
+					{Name: "Email", Type: "text", Default: "''::text"},
+				},
+				Constraints: []simplemigrate.Constraint{
+					{Name: "users_pkey", Definition: "PRIMARY KEY (id)"},
+				},
+			},
+			{Name: "accounts", DDL: "CREATE TABLE accounts (id INT);"},
+		},
+		Indexes: []simplemigrate.Index{
+			{Name: "idx_users_email", Table: "users", DDL: "CREATE INDEX idx_users_email ON users USING btree (\"Email\")"},
+			{Name: "idx_accounts_id", Table: "accounts", DDL: "CREATE INDEX idx_accounts_id ON accounts USING btree (id)"},
+		},
+	}
+
+	expected := `-- Schema dumped by simplemigrate. DO NOT EDIT.
+-- dialect: postgres
+
+CREATE TABLE accounts (id INT);
+
+CREATE TABLE users (
+    id integer NOT NULL,
+    "Email" text DEFAULT ''::text,
+    CONSTRAINT users_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX idx_accounts_id ON accounts USING btree (id);
+
+CREATE INDEX idx_users_email ON users USING btree ("Email");
+`
+
+	require.Equal(t, expected, schema.DDL())
+}
+
+func Test_DetectDrift(t *testing.T) {
+	t.Parallel()
+
+	folder := fstest.MapFS{
+		"1_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL);")},
+		"2_index.sql": {Data: []byte("CREATE INDEX idx_users_email ON users (email);")},
+	}
+
+	ctx := context.Background()
+
+	db, err := sqlite.Connect(filepath.Join(t.TempDir(), "drift.db"))
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	m := simplemigrate.New(sqlite.New(db), simplemigrate.WithEmbedFS(folder))
+
+	require.NoError(t, m.Migrate(ctx))
+
+	drifts, err := m.DetectDrift(ctx)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+
+	_, err = db.ExecContext(ctx, "ALTER TABLE users ADD COLUMN name TEXT")
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(ctx, "DROP INDEX idx_users_email")
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE hotfix (id INTEGER)")
+	require.NoError(t, err)
+
+	drifts, err = m.DetectDrift(ctx)
+	require.NoError(t, err)
+
+	require.ElementsMatch(t, []simplemigrate.Drift{
+		{Kind: simplemigrate.DriftTable, Name: "hotfix", Change: simplemigrate.DriftUnexpected},
+		{Kind: simplemigrate.DriftColumn, Name: "users.name", Change: simplemigrate.DriftUnexpected, Actual: "TEXT"},
+		{
+			Kind:     simplemigrate.DriftIndex,
+			Name:     "idx_users_email",
+			Change:   simplemigrate.DriftMissing,
+			Expected: "CREATE INDEX idx_users_email ON users (email)",
+		},
+	}, drifts)
+}
+
+func Test_DetectDrift_OutOfSync(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("should return an error when an applied file was edited", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := sqlite.Connect(filepath.Join(t.TempDir(), "drift.db"))
+		require.NoError(t, err)
+
+		defer db.Close()
+
+		folder := fstest.MapFS{
+			"1_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+		}
+
+		require.NoError(t, simplemigrate.New(sqlite.New(db), simplemigrate.WithEmbedFS(folder)).Migrate(ctx))
+
+		folder["1_users.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);")}
+
+		_, err = simplemigrate.New(sqlite.New(db), simplemigrate.WithEmbedFS(folder)).DetectDrift(ctx)
+		require.ErrorIs(t, err, simplemigrate.ErrOutOfSync)
+	})
+
+	t.Run("should return an error when an applied migration is dirty", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := sqlite.Connect(filepath.Join(t.TempDir(), "drift.db"))
+		require.NoError(t, err)
+
+		defer db.Close()
+
+		folder := fstest.MapFS{
+			"1_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+		}
+
+		m := simplemigrate.New(sqlite.New(db), simplemigrate.WithEmbedFS(folder))
+
+		require.NoError(t, m.Migrate(ctx))
+
+		// a migration that failed partway on a database without transactional DDL
+		_, err = db.ExecContext(ctx, "UPDATE schema_migrations SET dirty = TRUE WHERE version = 1")
+		require.NoError(t, err)
+
+		_, err = m.DetectDrift(ctx)
+		require.ErrorIs(t, err, simplemigrate.ErrDirtyMigration)
+	})
+}
+
+var errBusy = errors.New("database is locked")
+
+// retryableDriver is a mock driver that classifies errBusy as retryable
+type retryableDriver struct {
+	*mocks.MockDBDriver
+}
+
+func (retryableDriver) IsRetryable(err error) bool {
+	return errors.Is(err, errBusy)
+}
+
+// nonTransactionalRetryableDriver is a retryableDriver whose DDL is not transactional
+type nonTransactionalRetryableDriver struct {
+	retryableDriver
+}
+
+func (nonTransactionalRetryableDriver) TransactionalDDL() bool {
+	return false
+}
+
+func Test_Migrate_Retry(t *testing.T) {
+	t.Parallel()
+
+	const tbl = "schema_migrations"
+
+	policy := simplemigrate.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	t.Run("should retry a migration that fails with a retryable error", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+
+		gomock.InOrder(
+			driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(1)).Return(errBusy),
+			driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(1)).Return(nil),
+		)
+
+		m := simplemigrate.New(retryableDriver{driver},
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithRetry(policy),
+		)
+
+		err := m.Migrate(context.Background())
+		require.NoError(t, err)
+	})
+
+	t.Run("should only report the final attempt", func(t *testing.T) {
+		t.Parallel()
+
+		driver := memdriver.New(memdriver.FailOnStatement(1, fmt.Errorf("%w: locked", memdriver.ErrTransient)))
+		collector := metrics.New()
+
+		var printed strings.Builder
+
+		m := simplemigrate.New(driver,
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithRetry(policy),
+			simplemigrate.WithMetrics(collector),
+			simplemigrate.WithPrinter(func(format string, args ...any) {
+				fmt.Fprintf(&printed, format, args...)
+			}),
+		)
+
+		err := m.Migrate(context.Background())
+		require.NoError(t, err)
+
+		require.Contains(t, printed.String(), "1_demo.sql: attempt 1/3 failed")
+		require.Contains(t, printed.String(), "1_demo.sql...OK\n")
+		require.NotContains(t, printed.String(), "FAILED")
+
+		var out strings.Builder
+
+		_, err = collector.WriteTo(&out)
+		require.NoError(t, err)
+
+		require.Contains(t, out.String(), "simplemigrate_migrations_applied_total 1\n")
+		require.Contains(t, out.String(), "simplemigrate_migrations_failed_total 0\n")
+	})
+
+	t.Run("should give up after the maximum number of attempts", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(1)).Return(errBusy).Times(3)
+
+		m := simplemigrate.New(retryableDriver{driver},
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithRetry(policy),
+		)
+
+		err := m.Migrate(context.Background())
+		require.ErrorIs(t, err, errBusy)
+		require.ErrorIs(t, err, simplemigrate.ErrMigrationFailed)
+	})
+
+	t.Run("should not retry when the error is not retryable", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(1)).Return(errors.New("syntax error"))
+
+		m := simplemigrate.New(retryableDriver{driver},
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithRetry(policy),
+		)
+
+		err := m.Migrate(context.Background())
+		require.Error(t, err)
+	})
+
+	t.Run("should not retry when the driver does not support transactional DDL", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("mysql").AnyTimes()
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(1)).Return(errBusy)
+
+		m := simplemigrate.New(nonTransactionalRetryableDriver{retryableDriver{driver}},
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithRetry(policy),
+		)
+
+		err := m.Migrate(context.Background())
+		require.ErrorIs(t, err, errBusy)
+	})
+
+	t.Run("should not retry when all migrations run in a single transaction", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, true, gomock.Len(1)).Return(errBusy)
+
+		m := simplemigrate.New(retryableDriver{driver},
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithInTransaction(),
+			simplemigrate.WithRetry(policy),
+		)
+
+		err := m.Migrate(context.Background())
+		require.ErrorIs(t, err, errBusy)
+	})
+}
+
+func Test_Migrate_Progress(t *testing.T) {
+	t.Parallel()
+
+	const tbl = "schema_migrations"
+
+	folder := fstest.MapFS{
+		"1_a.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+		"2_b.sql": {Data: []byte("CREATE TABLE b (id INT);\n-- migrate:next\nCREATE TABLE c (id INT);")},
+		"3_d.sql": {Data: []byte("CREATE TABLE d (id INT);")},
+	}
+
+	h := sha256.Sum256(folder["1_a.sql"].Data)
+
+	applied := simplemigrate.Migration{
+		Version:       1,
+		Fname:         "1_a.sql",
+		Hash:          fmt.Sprintf("%x", h),
+		ExecutionTime: 10 * time.Second,
+	}
+
+	mctrl := gomock.NewController(t)
+	defer mctrl.Finish()
+
+	driver := mocks.NewMockDBDriver(mctrl)
+	driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+	driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+	driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return([]simplemigrate.Migration{applied}, nil)
+	driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(2)).
+		DoAndReturn(func(ctx context.Context, _ string, _ bool, migrations []simplemigrate.Migration) error {
+			for _, migration := range migrations {
+				mctx, done := simplemigrate.StartMigration(ctx, migration)
+
+				for i := range migration.Statements {
+					_, stmtDone := simplemigrate.StartStatement(mctx, migration, i)
+					stmtDone(0, nil)
+				}
+
+				done(nil)
+			}
+
+			return nil
+		})
+
+	var events []simplemigrate.Progress
+
+	m := simplemigrate.New(driver,
+		simplemigrate.WithEmbedFS(folder),
+		simplemigrate.WithProgress(func(p simplemigrate.Progress) {
+			events = append(events, p)
+		}),
+	)
+
+	err := m.Migrate(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, events, 7)
+
+	start := events[0]
+	require.Equal(t, 1, start.Index)
+	require.Equal(t, 2, start.Total)
+	require.Equal(t, "2_b.sql", start.Fname)
+	require.Equal(t, 0, start.Statement)
+	require.Equal(t, 1, start.Attempt)
+	require.False(t, start.Done)
+
+	first := events[1]
+	require.Equal(t, 1, first.Index)
+	require.Equal(t, 1, first.Statement)
+	require.Equal(t, 2, first.Statements)
+	require.False(t, first.Done)
+	require.InDelta(t, 20*time.Second, first.ETA, float64(time.Second))
+
+	require.Equal(t, 2, events[2].Statement)
+	require.True(t, events[3].Done)
+	require.Equal(t, 2, events[4].Index)
+	require.Equal(t, "3_d.sql", events[4].Fname)
+	require.Equal(t, 0, events[4].Statement)
+	require.True(t, events[6].Done)
+}
+
+func Test_Migrate_ProgressRetry(t *testing.T) {
+	t.Parallel()
+
+	const tbl = "schema_migrations"
+
+	mctrl := gomock.NewController(t)
+	defer mctrl.Finish()
+
+	apply := func(err error) func(context.Context, string, bool, []simplemigrate.Migration) error {
+		return func(ctx context.Context, _ string, _ bool, migrations []simplemigrate.Migration) error {
+			_, done := simplemigrate.StartMigration(ctx, migrations[0])
+			done(err)
+
+			return err
+		}
+	}
+
+	driver := mocks.NewMockDBDriver(mctrl)
+	driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+	driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+	driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+
+	gomock.InOrder(
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(1)).DoAndReturn(apply(errBusy)),
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(1)).DoAndReturn(apply(nil)),
+	)
+
+	var events []simplemigrate.Progress
+
+	m := simplemigrate.New(retryableDriver{driver},
+		simplemigrate.WithSystemFS("testdata/migrations"),
+		simplemigrate.WithRetry(simplemigrate.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+		simplemigrate.WithProgress(func(p simplemigrate.Progress) {
+			events = append(events, p)
+		}),
+	)
+
+	err := m.Migrate(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, events, 4)
+
+	require.Equal(t, 1, events[0].Attempt)
+
+	retry := events[1]
+	require.True(t, retry.Retry)
+	require.False(t, retry.Done)
+	require.ErrorIs(t, retry.Err, errBusy)
+	require.Equal(t, time.Millisecond, retry.Backoff)
+
+	require.Equal(t, 2, events[2].Attempt)
+	require.True(t, events[3].Done)
+	require.NoError(t, events[3].Err)
+	require.Equal(t, 2, events[3].Attempt)
+}
+
+type spanNameKey struct{}
+
+type recordingSpan struct {
+	name   string
+	parent string
+	attrs  []simplemigrate.Attribute
+	ended  bool
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...simplemigrate.Attribute) {
+	s.attrs = append(s.attrs, attrs...)
+}
+
+func (s *recordingSpan) RecordError(error) {}
+
+func (s *recordingSpan) End() {
+	s.ended = true
+}
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string, attrs ...simplemigrate.Attribute) (context.Context, simplemigrate.Span) {
+	parent, _ := ctx.Value(spanNameKey{}).(string)
+
+	s := &recordingSpan{name: name, parent: parent, attrs: attrs}
+	r.spans = append(r.spans, s)
+
+	return context.WithValue(ctx, spanNameKey{}, name), s
+}
+
+func (r *recordingTracer) names() []string {
+	ans := make([]string, 0, len(r.spans))
+	for _, s := range r.spans {
+		ans = append(ans, s.name)
+	}
+
+	return ans
+}
+
+// lockingDriver is a mock driver that records lock calls and may not support transactional DDL
+type lockingDriver struct {
+	*mocks.MockDBDriver
+	transactionalDDL bool
+	locked           int
+	unlocked         int
+}
+
+func (d *lockingDriver) Lock(context.Context, string) (func(context.Context) error, error) {
+	d.locked++
+
+	return func(context.Context) error {
+		d.unlocked++
+
+		return nil
+	}, nil
+}
+
+func (d *lockingDriver) TransactionalDDL() bool {
+	return d.transactionalDDL
+}
+
+func Test_Migrate_Lock(t *testing.T) {
+	t.Parallel()
+
+	const tbl = "schema_migrations"
+
+	t.Run("should hold the lock while migrating", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("mysql").AnyTimes()
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil)
+		driver.EXPECT().ApplyMigrations(gomock.Any(), tbl, false, gomock.Len(1)).Return(nil)
+
+		ld := &lockingDriver{MockDBDriver: driver}
+
+		m := simplemigrate.New(ld, simplemigrate.WithSystemFS("testdata/migrations"))
+
+		err := m.Migrate(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, ld.locked)
+		require.Equal(t, 1, ld.unlocked)
+	})
+
+	t.Run("should hold the lock while reading the history", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("mysql").AnyTimes()
+
+		ld := &lockingDriver{MockDBDriver: driver}
+
+		// the table may be created or upgraded, which must not race with Migrate
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).DoAndReturn(func(context.Context, string) error {
+			require.Equal(t, 1, ld.locked)
+			require.Zero(t, ld.unlocked)
+
+			return nil
+		}).Times(2)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return(nil, nil).Times(2)
+
+		m := simplemigrate.New(ld, simplemigrate.WithSystemFS("testdata/migrations"))
+
+		_, err := m.History(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, ld.unlocked)
+
+		ld.locked, ld.unlocked = 0, 0
+
+		statuses, err := m.Status(context.Background())
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		require.Equal(t, 1, ld.unlocked)
+	})
+
+	t.Run("should refuse WithInTransaction without transactional DDL", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("mysql").AnyTimes()
+
+		ld := &lockingDriver{MockDBDriver: driver}
+
+		m := simplemigrate.New(ld,
+			simplemigrate.WithSystemFS("testdata/migrations"),
+			simplemigrate.WithInTransaction(),
+		)
+
+		err := m.Migrate(context.Background())
+		require.ErrorIs(t, err, simplemigrate.ErrTransactionalDDLUnsupported)
+		require.Zero(t, ld.locked)
+	})
+}
+
+func Test_Migrate_Dirty(t *testing.T) {
+	t.Parallel()
+
+	const tbl = "schema_migrations"
+
+	t.Run("should refuse to migrate while a migration is dirty", func(t *testing.T) {
+		t.Parallel()
+
+		mctrl := gomock.NewController(t)
+		defer mctrl.Finish()
+
+		driver := mocks.NewMockDBDriver(mctrl)
+		driver.EXPECT().Dialect().Return("mysql").AnyTimes()
+		driver.EXPECT().CreateMigrationsTable(gomock.Any(), tbl).Return(nil)
+		driver.EXPECT().SelectMigrations(gomock.Any(), tbl).Return([]simplemigrate.Migration{
+			{Version: 1, Fname: "1_demo.sql", Dirty: true},
+		}, nil)
+
+		m := simplemigrate.New(driver, simplemigrate.WithSystemFS("testdata/migrations"))
+
+		err := m.Migrate(context.Background())
+		require.ErrorIs(t, err, simplemigrate.ErrDirtyMigration)
+	})
+
+	t.Run("should resolve a dirty migration", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := sqlite.Connect(filepath.Join(t.TempDir(), "test.db"))
+		require.NoError(t, err)
+
+		dialect := sqlite.Dialect
+		dialect.TransactionalDDL = false
+
+		driver := sqldriver.New(db, dialect)
+		defer driver.Close(context.Background())
+
+		folder := fstest.MapFS{
+			"1_users.sql": {Data: []byte("CREATE TABLE users (id INTEGER);\n-- migrate:next\nTHIS IS NOT SQL;")},
+		}
+
+		m := simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder))
+
+		require.Error(t, m.Migrate(context.Background()))
+		require.ErrorIs(t, m.Migrate(context.Background()), simplemigrate.ErrDirtyMigration)
+
+		require.NoError(t, m.Resolve(context.Background(), 1, true))
+		require.ErrorIs(t, m.Resolve(context.Background(), 1, true), simplemigrate.ErrNotDirty)
+
+		require.NoError(t, m.Migrate(context.Background()))
+	})
+
+	t.Run("should return ErrResolveUnsupported for drivers without Resolver", func(t *testing.T) {
+		t.Parallel()
+
+		m := simplemigrate.New(memdriver.New())
+
+		err := m.Resolve(context.Background(), 1, true)
+		require.ErrorIs(t, err, simplemigrate.ErrResolveUnsupported)
+	})
+}
+
+func Test_Status(t *testing.T) {
+	t.Parallel()
+
+	folder := fstest.MapFS{
+		"1_a.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+		"2_b.sql": {Data: []byte("CREATE TABLE b (id INT);")},
+	}
+
+	driver := memdriver.New()
+	ctx := context.Background()
+
+	err := simplemigrate.New(driver, simplemigrate.WithEmbedFS(fstest.MapFS{"1_a.sql": folder["1_a.sql"]})).Migrate(ctx)
+	require.NoError(t, err)
+
+	statuses, err := simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder)).Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+
+	require.True(t, statuses[0].Applied)
+	require.False(t, statuses[0].Modified)
+	require.NotNil(t, statuses[0].AppliedAt)
+	require.Equal(t, "2_b.sql", statuses[1].Fname)
+	require.True(t, statuses[1].Pending())
+
+	t.Run("should flag modified and missing files", func(t *testing.T) {
+		t.Parallel()
+
+		changed := fstest.MapFS{
+			"1_a.sql": {Data: []byte("CREATE TABLE a (id BIGINT);")},
+		}
+
+		statuses, err := simplemigrate.New(driver, simplemigrate.WithEmbedFS(changed)).Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		require.True(t, statuses[0].Modified)
+
+		err = simplemigrate.New(driver, simplemigrate.WithEmbedFS(changed)).Migrate(ctx)
+		require.ErrorIs(t, err, simplemigrate.ErrOutOfSync)
+		require.ErrorIs(t, err, simplemigrate.ErrInvalidMigrationFile)
+
+		statuses, err = simplemigrate.New(driver, simplemigrate.WithEmbedFS(fstest.MapFS{})).Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		require.True(t, statuses[0].Missing)
+		require.Equal(t, "1_a.sql", statuses[0].Fname)
+	})
+}
+
+func Test_Validate(t *testing.T) {
+	t.Parallel()
+
+	mctrl := gomock.NewController(t)
+	defer mctrl.Finish()
+
+	// the database is not used, only the dialect is passed to the validators
+	driver := mocks.NewMockDBDriver(mctrl)
+	driver.EXPECT().Dialect().Return("sqlite").AnyTimes()
+
+	folder := fstest.MapFS{
+		"1_a.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+		"2_b.sql": {Data: []byte("DROP TABLE a;")},
+	}
+
+	validator := mocks.NewMockQueryValidator(mctrl)
+	validator.EXPECT().ValidateQuery(gomock.Any(), "sqlite", "CREATE TABLE a (id INT);").Return(nil)
+	validator.EXPECT().ValidateQuery(gomock.Any(), "sqlite", "DROP TABLE a;").Return(errors.New("destructive"))
+
+	m := simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder), simplemigrate.WithQueryValidator(validator))
+
+	_, err := m.Validate(context.Background())
+	require.ErrorIs(t, err, simplemigrate.ErrInvalidQuery)
+
+	_, err = simplemigrate.New(driver, simplemigrate.WithEmbedFS(fstest.MapFS{
+		"2_b.sql": {Data: []byte("DROP TABLE a;")},
+	})).Validate(context.Background())
+	require.ErrorIs(t, err, simplemigrate.ErrInvalidMigrationFile)
+}
+
+func Test_Baseline(t *testing.T) {
+	t.Parallel()
+
+	folder := fstest.MapFS{
+		"1_a.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+		"2_b.sql": {Data: []byte("CREATE TABLE b (id INT);")},
+		"3_c.sql": {Data: []byte("CREATE TABLE c (id INT);")},
+	}
+
+	driver := memdriver.New()
+	ctx := context.Background()
+
+	m := simplemigrate.New(driver, simplemigrate.WithEmbedFS(folder), simplemigrate.WithAppliedBy("dba"))
+
+	err := m.Baseline(ctx, 4)
+	require.ErrorIs(t, err, simplemigrate.ErrBaseline)
+
+	err = m.Baseline(ctx, 2)
+	require.NoError(t, err)
+
+	// nothing is executed, only the history is written
+	require.Empty(t, driver.Executed())
+
+	history, err := m.History(ctx)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, "dba", history[1].AppliedBy)
+
+	err = m.Migrate(ctx)
+	require.NoError(t, err)
+
+	require.Len(t, driver.Executed(), 1)
+	require.Equal(t, "CREATE TABLE c (id INT);", driver.Executed()[0].Query)
+
+	err = m.Baseline(ctx, 1)
+	require.ErrorIs(t, err, simplemigrate.ErrBaseline)
+}
+
+func Test_Migrate_TemplateVars(t *testing.T) {
+	t.Parallel()
+
+	folder := fstest.MapFS{
+		"1_a.sql": {Data: []byte("CREATE TABLE {{ .schema }}.a (id INT);")},
+	}
+
+	driver := memdriver.New()
+
+	m := simplemigrate.New(driver,
+		simplemigrate.WithEmbedFS(folder),
+		simplemigrate.WithTemplateVars(map[string]string{"schema": "app"}),
+	)
+
+	err := m.Migrate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE app.a (id INT);", driver.Executed()[0].Query)
+
+	// the hash does not depend on the vars
+	statuses, err := simplemigrate.New(driver,
+		simplemigrate.WithEmbedFS(folder),
+		simplemigrate.WithTemplateVars(map[string]string{"schema": "other"}),
+	).Status(context.Background())
+	require.NoError(t, err)
+	require.False(t, statuses[0].Modified)
+
+	_, err = simplemigrate.New(driver,
+		simplemigrate.WithEmbedFS(folder),
+		simplemigrate.WithTemplateVars(map[string]string{"tenant": "app"}),
+	).Validate(context.Background())
+	require.ErrorIs(t, err, simplemigrate.ErrInvalidMigrationFile)
+}
+
+func Test_Migrate_Printer(t *testing.T) {
+	t.Parallel()
+
+	folder := fstest.MapFS{
+		"1_a.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+	}
+
+	var lines []string
+
+	m := simplemigrate.New(memdriver.New(),
+		simplemigrate.WithEmbedFS(folder),
+		simplemigrate.WithInTransaction(),
+		simplemigrate.WithPrinter(func(format string, args ...any) {
+			lines = append(lines, fmt.Sprintf(format, args...))
+		}),
+	)
+
+	err := m.Migrate(context.Background())
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"Migrating...\n",
+		"Migrations table: schema_migrations\n",
+		"Applying 1 migrations [start_version=1 end_version=1]\n",
+		"Applying migrations in transaction\n",
+		"1_a.sql...OK\n",
+	}, lines)
 }
@@ -0,0 +1,562 @@
+// Package sqldriver implements simplemigrate.DBDriver on top of database/sql
+// The differences between databases are described by a Dialect, so a new
+// database can be supported without writing a driver
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosom/simplemigrate"
+)
+
+// lockPrefix is the prefix of the name of the migration lock
+const lockPrefix = "simplemigrate:"
+
+// PlaceholderStyle is the style of the bind parameters of a database
+type PlaceholderStyle int
+
+const (
+	// Question is the ? style used by sqlite and mysql
+	Question PlaceholderStyle = iota
+	// Dollar is the $1 style used by postgres
+	Dollar
+	// AtP is the @p1 style used by sql server
+	AtP
+	// Colon is the :1 style used by oracle
+	Colon
+)
+
+// placeholder returns the bind parameter with 1-based index i
+func (p PlaceholderStyle) placeholder(i int) string {
+	switch p {
+	case Dollar:
+		return "$" + strconv.Itoa(i)
+	case AtP:
+		return "@p" + strconv.Itoa(i)
+	case Colon:
+		return ":" + strconv.Itoa(i)
+	default:
+		return "?"
+	}
+}
+
+// Dialect describes how a database differs from the others
+type Dialect struct {
+	// Name is returned by DBDriver.Dialect
+	Name string
+	// Placeholder is the style of the bind parameters
+	Placeholder PlaceholderStyle
+	// TextType is the column type of the text columns of the migrations table
+	// Defaults to TEXT
+	TextType string
+	// TimestampType is the column type of applied_at
+	TimestampType string
+	// BigIntType is the column type of execution_ms
+	// Defaults to BIGINT
+	BigIntType string
+	// BoolType is the column type of dirty
+	// Defaults to BOOLEAN
+	BoolType string
+	// FalseLiteral is the default value of dirty
+	// Defaults to FALSE
+	FalseLiteral string
+	// CreateTable returns the statement that creates the table (already quoted)
+	// with the column definitions if it does not exist
+	// Defaults to CREATE TABLE IF NOT EXISTS table (columns)
+	CreateTable func(table string, columns []string) string
+	// AddColumn returns the statement that adds the column definition to
+	// the table (already quoted)
+	// Defaults to ALTER TABLE table ADD COLUMN column
+	AddColumn func(table, column string) string
+	// TimeLayout is the layout used to store applied_at as text
+	// If empty, applied_at is passed to the database/sql driver as a time.Time
+	// Values that are scanned as text are parsed with it (or RFC3339Nano)
+	TimeLayout string
+	// QuoteIdentifier quotes an identifier
+	// If nil, identifiers are used as is
+	QuoteIdentifier func(name string) string
+	// CreateSchemas creates the schema of a schema qualified migrations table
+	// with CREATE SCHEMA IF NOT EXISTS
+	CreateSchemas bool
+	// TransactionalDDL reports whether DDL statements can be rolled back
+	TransactionalDDL bool
+	// IsRetryable reports whether an error is transient
+	// If nil, no error is retryable
+	IsRetryable func(err error) bool
+	// StatementTimeout returns a statement that makes the server cancel the
+	// statements that follow it in the transaction after timeout,
+	// and a statement that restores the default
+	// If nil, only the client cancels statements
+	StatementTimeout func(timeout time.Duration) (set, reset string)
+	// Lock acquires the lock called name on conn
+	// If nil, Migrate runs without a lock
+	Lock func(ctx context.Context, conn *sql.Conn, name string) error
+	// Unlock releases the lock called name on conn
+	Unlock func(ctx context.Context, conn *sql.Conn, name string) error
+}
+
+// quote quotes every part of a (possibly schema qualified) table name
+func (d *Dialect) quote(name string) string {
+	if d.QuoteIdentifier == nil {
+		return name
+	}
+
+	schema, table := simplemigrate.SplitTableName(name)
+	if schema == "" {
+		return d.QuoteIdentifier(table)
+	}
+
+	return d.QuoteIdentifier(schema) + "." + d.QuoteIdentifier(table)
+}
+
+// encodeTime returns the value of applied_at to insert
+func (d *Dialect) encodeTime(t time.Time) any {
+	if d.TimeLayout == "" {
+		return t
+	}
+
+	return t.Format(d.TimeLayout)
+}
+
+// decodeTime converts a scanned applied_at value
+func (d *Dialect) decodeTime(v any) (time.Time, error) {
+	layout := d.TimeLayout
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC(), nil
+	case []byte:
+		return time.ParseInLocation(layout, string(t), time.UTC)
+	case string:
+		return time.ParseInLocation(layout, t, time.UTC)
+	default:
+		return time.Time{}, fmt.Errorf("unexpected applied_at type %T", v)
+	}
+}
+
+// DB is implemented by *sql.DB and *sql.Conn
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	Close() error
+}
+
+// Driver is a database/sql driver configured by a Dialect
+// Database specific drivers embed it to add optional capabilities
+type Driver struct {
+	db      DB
+	dialect Dialect
+}
+
+var (
+	_ simplemigrate.DBDriver                 = (*Driver)(nil)
+	_ simplemigrate.RetryClassifier          = (*Driver)(nil)
+	_ simplemigrate.Locker                   = (*Driver)(nil)
+	_ simplemigrate.TransactionalDDLReporter = (*Driver)(nil)
+	_ simplemigrate.Resolver                 = (*Driver)(nil)
+)
+
+// New creates a new driver for db
+func New(db DB, dialect Dialect) *Driver {
+	if dialect.TextType == "" {
+		dialect.TextType = "TEXT"
+	}
+
+	if dialect.BigIntType == "" {
+		dialect.BigIntType = "BIGINT"
+	}
+
+	if dialect.BoolType == "" {
+		dialect.BoolType = "BOOLEAN"
+	}
+
+	if dialect.FalseLiteral == "" {
+		dialect.FalseLiteral = "FALSE"
+	}
+
+	if dialect.CreateTable == nil {
+		dialect.CreateTable = createTableIfNotExists
+	}
+
+	if dialect.AddColumn == nil {
+		dialect.AddColumn = addColumn
+	}
+
+	return &Driver{db: db, dialect: dialect}
+}
+
+// createTableIfNotExists is the default CreateTable of a Dialect
+func createTableIfNotExists(table string, columns []string) string {
+	return "CREATE TABLE IF NOT EXISTS " + table + " (" + strings.Join(columns, ", ") + ")"
+}
+
+// addColumn is the default AddColumn of a Dialect
+func addColumn(table, column string) string {
+	return "ALTER TABLE " + table + " ADD COLUMN " + column
+}
+
+// DB returns the database the driver uses
+func (d *Driver) DB() DB {
+	return d.db
+}
+
+// Close closes the connection to the database
+func (d *Driver) Close(_ context.Context) error {
+	return d.db.Close()
+}
+
+// Dialect returns the name of the dialect
+func (d *Driver) Dialect() string {
+	return d.dialect.Name
+}
+
+// TransactionalDDL returns true if DDL statements can be rolled back
+func (d *Driver) TransactionalDDL() bool {
+	return d.dialect.TransactionalDDL
+}
+
+// IsRetryable returns true if the dialect classifies err as transient
+func (d *Driver) IsRetryable(err error) bool {
+	return d.dialect.IsRetryable != nil && d.dialect.IsRetryable(err)
+}
+
+// Lock acquires the migration lock of the dialect on a dedicated connection
+// of a *sql.DB or on the *sql.Conn the driver uses
+// It does nothing if the dialect has no lock
+func (d *Driver) Lock(ctx context.Context, migrationsTable string) (func(context.Context) error, error) {
+	if d.dialect.Lock == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var (
+		c       *sql.Conn
+		release = func() error { return nil }
+	)
+
+	switch db := d.db.(type) {
+	case *sql.DB:
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c, release = conn, conn.Close
+	case *sql.Conn:
+		c = db
+	default:
+		return nil, fmt.Errorf("cannot lock on %T", d.db)
+	}
+
+	name := lockPrefix + migrationsTable
+
+	if err := d.dialect.Lock(ctx, c, name); err != nil {
+		_ = release()
+
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		defer release()
+
+		if d.dialect.Unlock == nil {
+			return nil
+		}
+
+		return d.dialect.Unlock(ctx, c, name)
+	}, nil
+}
+
+// CreateMigrationsTable creates the migrations table or upgrades its layout
+// to simplemigrate.MetaVersion
+// The schema of a schema qualified table is created if the dialect supports it
+func (d *Driver) CreateMigrationsTable(ctx context.Context, migrationsTable string) error {
+	if schema, _ := simplemigrate.SplitTableName(migrationsTable); schema != "" && d.dialect.CreateSchemas {
+		if _, err := d.db.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+d.dialect.quote(schema)); err != nil {
+			return err
+		}
+	}
+
+	return d.upgrade(ctx, migrationsTable)
+}
+
+// SelectMigrations selects all migrations from the migrations table
+// It returns a sorted slice (by Version ascending) of migrations or an error
+func (d *Driver) SelectMigrations(ctx context.Context, migrationsTable string) ([]simplemigrate.Migration, error) {
+	rows, err := d.db.QueryContext(ctx,
+		"SELECT version, fname, hash, applied_at, execution_ms, applied_by, hostname, app_version, tool_version, dirty FROM "+
+			d.dialect.quote(migrationsTable)+" ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var migrations []simplemigrate.Migration
+
+	for rows.Next() {
+		var (
+			m                                            simplemigrate.Migration
+			appliedAt                                    any
+			executionMs                                  sql.NullInt64
+			appliedBy, hostname, appVersion, toolVersion sql.NullString
+		)
+
+		err := rows.Scan(&m.Version, &m.Fname, &m.Hash, &appliedAt,
+			&executionMs, &appliedBy, &hostname, &appVersion, &toolVersion, &m.Dirty)
+		if err != nil {
+			return nil, err
+		}
+
+		m.ExecutionTime = time.Duration(executionMs.Int64) * time.Millisecond
+		m.AppliedBy = appliedBy.String
+		m.Hostname = hostname.String
+		m.AppVersion = appVersion.String
+		m.ToolVersion = toolVersion.String
+
+		t, err := d.dialect.decodeTime(appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		m.AppliedAt = &t
+
+		migrations = append(migrations, m)
+	}
+
+	return migrations, rows.Err()
+}
+
+// ApplyMigrations applies migrations to the database
+// migrationsTable is the name of the migrations table
+// If inTx is true, it applies all migrations in a transaction
+// It returns an error if one occurs
+func (d *Driver) ApplyMigrations(ctx context.Context, migrationsTable string, inTx bool, migrations []simplemigrate.Migration) error {
+	if inTx {
+		tx, err := d.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		err = d.applyMigrations(ctx, migrationsTable, tx, migrations)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+
+	return d.applyMigrations(ctx, migrationsTable, nil, migrations)
+}
+
+// queries are the statements that record the migrations in the migrations table
+type queries struct {
+	insert string
+	finish string
+}
+
+// historyQueries returns the statements that record the migrations in migrationsTable
+func (d *Driver) historyQueries(migrationsTable string) queries {
+	p := d.dialect.Placeholder
+
+	placeholders := make([]string, 0, 10)
+	for i := 1; i <= 10; i++ {
+		placeholders = append(placeholders, p.placeholder(i))
+	}
+
+	return queries{
+		insert: "INSERT INTO " + d.dialect.quote(migrationsTable) +
+			" (version, fname, hash, applied_at, execution_ms, applied_by, hostname, app_version, tool_version, dirty)" +
+			" VALUES (" + strings.Join(placeholders, ", ") + ")",
+		finish: "UPDATE " + d.dialect.quote(migrationsTable) +
+			" SET dirty = " + p.placeholder(1) + ", applied_at = " + p.placeholder(2) + ", execution_ms = " + p.placeholder(3) +
+			" WHERE version = " + p.placeholder(4),
+	}
+}
+
+func (d *Driver) applyMigrations(ctx context.Context, migrationsTable string, tx *sql.Tx, migrations []simplemigrate.Migration) error {
+	q := d.historyQueries(migrationsTable)
+
+	for _, m := range migrations {
+		if err := d.applyOne(ctx, q, tx, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) applyOne(ctx context.Context, q queries, tx *sql.Tx, m simplemigrate.Migration) (err error) {
+	ctx, done := simplemigrate.StartMigration(ctx, m)
+
+	defer func() {
+		done(err)
+	}()
+
+	start := time.Now()
+
+	// DDL that is not transactional is not rolled back when a statement fails,
+	// so the migration is recorded as dirty before it runs and is marked as
+	// applied once it succeeds
+	dirty := tx == nil && !d.dialect.TransactionalDDL
+
+	if dirty {
+		_, err = d.db.ExecContext(ctx, q.insert, m.Version, m.Fname, m.Hash, d.dialect.encodeTime(start.UTC()),
+			nil, m.AppliedBy, m.Hostname, m.AppVersion, m.ToolVersion, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	trans, rollback, commit, err := d.createTxIfNotExists(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = rollback()
+	}()
+
+	for i, query := range m.Statements {
+		if err = d.execStatement(ctx, trans, m, i, query); err != nil {
+			return err
+		}
+	}
+
+	if dirty {
+		_, err = trans.ExecContext(ctx, q.finish, false, d.dialect.encodeTime(time.Now().UTC()),
+			time.Since(start).Milliseconds(), m.Version)
+	} else {
+		_, err = trans.ExecContext(ctx, q.insert, m.Version, m.Fname, m.Hash, d.dialect.encodeTime(time.Now().UTC()),
+			time.Since(start).Milliseconds(), m.AppliedBy, m.Hostname, m.AppVersion, m.ToolVersion, false)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResolveMigration clears the dirty state of the migration with version
+// If applied is true the migration is marked as applied,
+// otherwise its row is deleted so that it runs again
+func (d *Driver) ResolveMigration(ctx context.Context, migrationsTable string, version int, applied bool) error {
+	p := d.dialect.Placeholder
+
+	query := "DELETE FROM " + d.dialect.quote(migrationsTable) +
+		" WHERE version = " + p.placeholder(1) + " AND dirty = " + p.placeholder(2)
+	args := []any{version, true}
+
+	if applied {
+		query = "UPDATE " + d.dialect.quote(migrationsTable) + " SET dirty = " + p.placeholder(1) +
+			" WHERE version = " + p.placeholder(2) + " AND dirty = " + p.placeholder(3)
+		args = []any{false, version, true}
+	}
+
+	res, err := d.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %d", simplemigrate.ErrNotDirty, version)
+	}
+
+	return nil
+}
+
+// execStatement executes the statement with index idx of migration m
+func (d *Driver) execStatement(ctx context.Context, tx *sql.Tx, m simplemigrate.Migration, idx int, query string) error {
+	ctx, done := simplemigrate.StartStatement(ctx, m, idx)
+
+	reset, err := d.setServerTimeout(ctx, tx)
+	if err != nil {
+		done(-1, err)
+
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, query)
+	if err != nil {
+		done(-1, err)
+
+		return err
+	}
+
+	if err := reset(); err != nil {
+		done(-1, err)
+
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		rowsAffected = -1
+	}
+
+	done(rowsAffected, nil)
+
+	return nil
+}
+
+// setServerTimeout makes the server cancel the next statement when
+// the deadline of ctx is reached, so it does not keep running after the client gave up
+// It returns a function that restores the default
+func (d *Driver) setServerTimeout(ctx context.Context, tx *sql.Tx) (func() error, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok || d.dialect.StatementTimeout == nil {
+		return func() error { return nil }, nil
+	}
+
+	timeout := time.Until(deadline)
+	if timeout < time.Millisecond {
+		timeout = time.Millisecond
+	}
+
+	set, reset := d.dialect.StatementTimeout(timeout)
+
+	if _, err := tx.ExecContext(ctx, set); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		_, err := tx.ExecContext(ctx, reset)
+
+		return err
+	}, nil
+}
+
+//nolint:gocritic // TODO: refactor
+func (d *Driver) createTxIfNotExists(
+	ctx context.Context,
+	tx *sql.Tx,
+) (*sql.Tx, func() error, func() error, error) {
+	if tx != nil {
+		return tx, func() error { return nil }, func() error { return nil }, nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return tx, tx.Rollback, tx.Commit, nil
+}
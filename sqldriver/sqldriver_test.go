@@ -0,0 +1,220 @@
+package sqldriver_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/drivertest"
+	"github.com/gosom/simplemigrate/sqldriver"
+	"github.com/gosom/simplemigrate/sqlite"
+)
+
+// TestConformance runs the conformance suite with a dialect that is only
+// described by a struct, on top of the sqlite database/sql driver
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	dialect := sqldriver.Dialect{
+		Name:             "custom",
+		Placeholder:      sqldriver.Dollar,
+		TimestampType:    "TIMESTAMP",
+		TimeLayout:       "2006-01-02 15:04:05.999999999",
+		TransactionalDDL: true,
+	}
+
+	t.Run("transactional DDL", func(t *testing.T) {
+		drivertest.Run(t, newFactory(dialect))
+	})
+
+	// the migrations are recorded as dirty while they run
+	dialect.TransactionalDDL = false
+
+	t.Run("non-transactional DDL", func(t *testing.T) {
+		drivertest.Run(t, newFactory(dialect))
+	})
+}
+
+// newFactory returns a factory of drivers for dialect on sqlite databases
+func newFactory(dialect sqldriver.Dialect) drivertest.Factory {
+	return func(t *testing.T) simplemigrate.DBDriver {
+		t.Helper()
+
+		db, err := sqlite.Connect(filepath.Join(t.TempDir(), "test.db"))
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+
+		return sqldriver.New(db, dialect)
+	}
+}
+
+func TestDriver_CreateMigrationsTable(t *testing.T) {
+	t.Parallel()
+
+	newDB := func(t *testing.T) *sql.DB {
+		t.Helper()
+
+		db, err := sqlite.Connect(filepath.Join(t.TempDir(), "test.db"))
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+
+		return db
+	}
+
+	metaVersion := func(t *testing.T, db *sql.DB) int {
+		t.Helper()
+
+		var version int
+
+		err := db.QueryRow("SELECT version FROM schema_migrations_meta").Scan(&version)
+		require.NoError(t, err)
+
+		return version
+	}
+
+	t.Run("should upgrade a table created by an older version", func(t *testing.T) {
+		t.Parallel()
+
+		db := newDB(t)
+
+		_, err := db.Exec(`CREATE TABLE schema_migrations (
+			version INTEGER NOT NULL PRIMARY KEY,
+			fname TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)`)
+		require.NoError(t, err)
+
+		_, err = db.Exec(`INSERT INTO schema_migrations VALUES (1, '1_init.sql', 'h1', '2023-11-05T10:30:00Z')`)
+		require.NoError(t, err)
+
+		driver := sqlite.New(db)
+		ctx := context.Background()
+
+		require.NoError(t, driver.CreateMigrationsTable(ctx, "schema_migrations"))
+		require.Equal(t, simplemigrate.MetaVersion, metaVersion(t, db))
+
+		migrations, err := driver.SelectMigrations(ctx, "schema_migrations")
+		require.NoError(t, err)
+		require.Len(t, migrations, 1)
+		require.Equal(t, "1_init.sql", migrations[0].Fname)
+		require.False(t, migrations[0].Dirty)
+
+		// a second call finds the layout up to date
+		require.NoError(t, driver.CreateMigrationsTable(ctx, "schema_migrations"))
+		require.Equal(t, simplemigrate.MetaVersion, metaVersion(t, db))
+	})
+
+	t.Run("should refuse a table written by a newer version", func(t *testing.T) {
+		t.Parallel()
+
+		db := newDB(t)
+
+		_, err := db.Exec(`CREATE TABLE schema_migrations_meta (version INTEGER NOT NULL)`)
+		require.NoError(t, err)
+
+		_, err = db.Exec(`INSERT INTO schema_migrations_meta VALUES (?)`, simplemigrate.MetaVersion+1)
+		require.NoError(t, err)
+
+		err = sqlite.New(db).CreateMigrationsTable(context.Background(), "schema_migrations")
+		require.ErrorIs(t, err, simplemigrate.ErrMetaVersion)
+	})
+}
+
+func TestDriver_DialectDDL(t *testing.T) {
+	t.Parallel()
+
+	db, err := sqlite.Connect(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	var created, added []string
+
+	// a dialect without BOOLEAN, BIGINT and ADD COLUMN, like sql server
+	dialect := sqldriver.Dialect{
+		Name:          "custom",
+		Placeholder:   sqldriver.Question,
+		TimestampType: "DATETIME",
+		BigIntType:    "INTEGER",
+		BoolType:      "BIT",
+		FalseLiteral:  "0",
+		CreateTable: func(table string, columns []string) string {
+			created = append(created, table)
+
+			return "CREATE TABLE IF NOT EXISTS " + table + " (" + strings.Join(columns, ", ") + ")"
+		},
+		AddColumn: func(table, column string) string {
+			added = append(added, column)
+
+			return "ALTER TABLE " + table + " ADD " + column
+		},
+	}
+
+	driver := sqldriver.New(db, dialect)
+
+	require.NoError(t, driver.CreateMigrationsTable(context.Background(), "schema_migrations"))
+
+	require.Equal(t, []string{"schema_migrations_meta", "schema_migrations"}, created)
+	require.Contains(t, added, "execution_ms INTEGER")
+	require.Contains(t, added, "dirty BIT DEFAULT 0 NOT NULL")
+
+	migrations, err := driver.SelectMigrations(context.Background(), "schema_migrations")
+	require.NoError(t, err)
+	require.Empty(t, migrations)
+}
+
+func TestDriver_Lock(t *testing.T) {
+	t.Parallel()
+
+	db, err := sqlite.Connect(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	var locked, unlocked *sql.Conn
+
+	dialect := sqlite.Dialect
+	dialect.Lock = func(_ context.Context, c *sql.Conn, _ string) error {
+		locked = c
+
+		return nil
+	}
+	dialect.Unlock = func(_ context.Context, c *sql.Conn, _ string) error {
+		unlocked = c
+
+		return nil
+	}
+
+	unlock, err := sqldriver.New(conn, dialect).Lock(context.Background(), "schema_migrations")
+	require.NoError(t, err)
+	require.Same(t, conn, locked)
+
+	require.NoError(t, unlock(context.Background()))
+	require.Same(t, conn, unlocked)
+
+	// the connection of the driver stays open
+	require.NoError(t, conn.PingContext(context.Background()))
+}
@@ -0,0 +1,171 @@
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/gosom/simplemigrate"
+)
+
+// column is a column of the migrations table
+type column struct {
+	name       string
+	definition string
+}
+
+// createTable returns the statement that creates migrationsTable with the
+// layout of meta version 1
+func (d *Driver) createTable(migrationsTable string) string {
+	text := d.dialect.TextType
+
+	return d.dialect.CreateTable(d.dialect.quote(migrationsTable), []string{
+		"version INTEGER NOT NULL PRIMARY KEY",
+		"fname " + text + " NOT NULL",
+		"hash " + text + " NOT NULL",
+		"applied_at " + d.dialect.TimestampType + " NOT NULL",
+	})
+}
+
+// addedColumns returns the columns that the later meta versions add, in order
+func (d *Driver) addedColumns() []column {
+	text := d.dialect.TextType
+
+	return []column{
+		{"execution_ms", d.dialect.BigIntType},
+		{"applied_by", text},
+		{"hostname", text},
+		{"app_version", text},
+		{"tool_version", text},
+		{"dirty", d.dialect.BoolType + " DEFAULT " + d.dialect.FalseLiteral + " NOT NULL"},
+	}
+}
+
+// upgrade brings the layout of migrationsTable to simplemigrate.MetaVersion
+// and records it in the meta table
+// It refuses to touch tables with a newer layout
+// Only the missing columns are added, so an upgrade that was interrupted
+// (DDL is not transactional on every database) is completed by the next one
+func (d *Driver) upgrade(ctx context.Context, migrationsTable string) error {
+	metaTable := d.dialect.quote(simplemigrate.MetaTableName(migrationsTable))
+
+	_, err := d.db.ExecContext(ctx, d.dialect.CreateTable(metaTable, []string{"version INTEGER NOT NULL"}))
+	if err != nil {
+		return err
+	}
+
+	version, recorded, err := d.metaVersion(ctx, migrationsTable)
+	if err != nil {
+		return err
+	}
+
+	if err := simplemigrate.CheckMetaVersion(version); err != nil {
+		return err
+	}
+
+	if recorded && version == simplemigrate.MetaVersion {
+		return nil
+	}
+
+	if _, err := d.db.ExecContext(ctx, d.createTable(migrationsTable)); err != nil {
+		return err
+	}
+
+	columns, err := d.columns(ctx, migrationsTable)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, c := range d.addedColumns() {
+		if columns[c.name] {
+			continue
+		}
+
+		query := d.dialect.AddColumn(d.dialect.quote(migrationsTable), c.name+" "+c.definition)
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+metaTable); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+metaTable+" (version) VALUES ("+d.dialect.Placeholder.placeholder(1)+")",
+		simplemigrate.MetaVersion)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// metaVersion returns the meta version of migrationsTable recorded in the
+// meta table and whether it is recorded
+func (d *Driver) metaVersion(ctx context.Context, migrationsTable string) (int, bool, error) {
+	var version int
+
+	err := scanOne(ctx, d.db, "SELECT version FROM "+d.dialect.quote(simplemigrate.MetaTableName(migrationsTable)), &version)
+
+	switch {
+	case err == nil:
+		return version, true, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, false, nil
+	default:
+		return 0, false, err
+	}
+}
+
+// columns returns the names of the columns of migrationsTable
+func (d *Driver) columns(ctx context.Context, migrationsTable string) (map[string]bool, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT * FROM "+d.dialect.quote(migrationsTable)+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	ans := make(map[string]bool, len(names))
+	for _, name := range names {
+		ans[strings.ToLower(name)] = true
+	}
+
+	return ans, rows.Err()
+}
+
+// scanOne scans the first row of query into dest
+// It returns sql.ErrNoRows if there are no rows
+func scanOne(ctx context.Context, db DB, query string, dest ...any) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return sql.ErrNoRows
+	}
+
+	return rows.Scan(dest...)
+}
@@ -3,22 +3,46 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
-	_ "modernc.org/sqlite" // sqlite driver
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 
 	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/sqldriver"
 )
 
+// Dialect describes sqlite to sqldriver
+var Dialect = sqldriver.Dialect{
+	Name:            "sqlite",
+	Placeholder:     sqldriver.Question,
+	TimestampType:   "DATETIME",
+	TimeLayout:      time.RFC3339Nano,
+	QuoteIdentifier: quoteIdentifier,
+	// DDL statements are transactional in sqlite
+	TransactionalDDL: true,
+	IsRetryable:      isRetryable,
+}
+
+func init() {
+	for _, scheme := range []string{"sqlite", "sqlite3", "file"} {
+		simplemigrate.RegisterDriver(scheme, open)
+	}
+}
+
 // driver is a struct that represents a sqlite driver
 type driver struct {
-	db *sql.DB
+	*sqldriver.Driver
 }
 
 // New creates a new sqlite driver
 func New(db *sql.DB) simplemigrate.DBDriver {
-	return &driver{db: db}
+	return &driver{Driver: sqldriver.New(db, Dialect)}
 }
 
 // Connect connects to a sqlite database
@@ -26,157 +50,312 @@ func Connect(path string) (*sql.DB, error) {
 	return sql.Open("sqlite", path)
 }
 
-// Close closes the connection to the database
-func (d *driver) Close(_ context.Context) error {
-	return d.db.Close()
-}
+// open connects to the database of a sqlite://, sqlite3:// or file: url
+func open(ctx context.Context, connURL string) (simplemigrate.DBDriver, error) {
+	dsn, err := ParseURL(connURL)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := Connect(dsn)
+	if err != nil {
+		return nil, err
+	}
 
-// Dialect returns the database dialect
-func (d *driver) Dialect() string {
-	return "sqlite"
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+
+		return nil, err
+	}
+
+	return New(db), nil
 }
 
-// CreateMigrationsTable creates the migrations table
-// If the table already exists, it does nothing
-func (d *driver) CreateMigrationsTable(ctx context.Context, migrationsTable string) error {
-	_, err := d.db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS `+migrationsTable+` (
-			version INTEGER NOT NULL PRIMARY KEY,
-			fname TEXT NOT NULL,
-			hash TEXT NOT NULL,
-			applied_at DATETIME NOT NULL
-		)
-	`)
+// pragmas are the query parameters of a url that are set as pragmas
+var pragmas = map[string]bool{
+	"busy_timeout": true,
+	"cache_size":   true,
+	"foreign_keys": true,
+	"journal_mode": true,
+	"locking_mode": true,
+	"synchronous":  true,
+	"temp_store":   true,
+}
 
-	return err
+// params are the query parameters that are passed to the sqlite driver as they are
+var params = map[string]bool{
+	"_pragma":      true,
+	"_time_format": true,
+	"_txlock":      true,
+	"cache":        true,
+	"mode":         true,
+	"vfs":          true,
 }
 
-// SelectMigrations selects all migrations from the migrations table
-// It returns a sorted slice (by Version ascending) of migrations or an error
-func (d *driver) SelectMigrations(ctx context.Context, migrationsTable string) ([]simplemigrate.Migration, error) {
-	//nolint:gosec // migrations table should be safe
-	rows, err := d.db.QueryContext(ctx,
-		"SELECT version, fname, hash, applied_at FROM "+migrationsTable+" ORDER BY version")
+// pragmaValueRe matches the values of the pragmas of a url
+var pragmaValueRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ParseURL converts a sqlite://path, sqlite3://path or file:path URL
+// to a modernc.org/sqlite DSN
+// sqlite:///var/data/app.db is an absolute path, sqlite://./data/app.db and
+// sqlite://app.db are relative to the working directory
+// :memory: is an in-memory database with a shared cache, so that every
+// connection of the pool sees the same database
+// The pragma parameters (e.g. busy_timeout=5000, journal_mode=WAL or
+// foreign_keys=on) become _pragma parameters, which the driver runs on
+// every connection it opens
+func ParseURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	defer rows.Close()
+	switch u.Scheme {
+	case "sqlite", "sqlite3", "file":
+	default:
+		return "", fmt.Errorf("invalid sqlite url scheme: %s", u.Scheme)
+	}
 
-	var migrations []simplemigrate.Migration
+	// sqlite:app.db and file:app.db are opaque, the others have a host and a path
+	path := u.Opaque
+	if path == "" {
+		path = u.Host + u.Path
+	}
 
-	for rows.Next() {
-		var m simplemigrate.Migration
+	if path == "" {
+		return "", fmt.Errorf("sqlite url %s has no path", u.Redacted())
+	}
 
-		var appliedAt string
+	// the pragmas run in the order of the url, e.g. busy_timeout before journal_mode
+	var query []string
 
-		err := rows.Scan(&m.Version, &m.Fname, &m.Hash, &appliedAt)
-		if err != nil {
-			return nil, err
+	hasCache := false
+
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
 		}
 
-		t, err := time.Parse(time.RFC3339Nano, appliedAt)
-		if err != nil {
-			return nil, err
+		key, value, _ := strings.Cut(param, "=")
+
+		if key, err = url.QueryUnescape(key); err != nil {
+			return "", err
 		}
 
-		m.AppliedAt = &t
+		if value, err = url.QueryUnescape(value); err != nil {
+			return "", err
+		}
 
-		migrations = append(migrations, m)
+		switch {
+		case pragmas[key]:
+			if !pragmaValueRe.MatchString(value) {
+				return "", fmt.Errorf("invalid value of sqlite pragma %s: %q", key, value)
+			}
+
+			query = append(query, "_pragma="+url.QueryEscape(key+"("+value+")"))
+		case params[key]:
+			query = append(query, url.QueryEscape(key)+"="+url.QueryEscape(value))
+			hasCache = hasCache || key == "cache"
+		default:
+			return "", fmt.Errorf("unknown sqlite url parameter: %s", key)
+		}
 	}
 
-	return migrations, nil
-}
+	if path == ":memory:" && !hasCache {
+		query = append(query, "cache=shared")
+	}
 
-// ApplyMigrations applies migrations to the database
-// migrationsTable is the name of the migrations table
-// If inTx is true, it applies all migrations in a transaction
-// It returns an error if one occurs
-func (d *driver) ApplyMigrations(ctx context.Context, migrationsTable string, inTx bool, migrations []simplemigrate.Migration) error {
-	if inTx {
-		fmt.Println("Applying migrations in transaction")
+	dsn := "file:" + uriPathReplacer.Replace(path)
 
-		tx, err := d.db.BeginTx(ctx, nil)
-		if err != nil {
-			return err
-		}
+	// an absolute path gets an empty authority, so that //path is not read as a host
+	if strings.HasPrefix(path, "/") {
+		dsn = "file://" + uriPathReplacer.Replace(path)
+	}
+	if len(query) > 0 {
+		dsn += "?" + strings.Join(query, "&")
+	}
 
-		defer func() {
-			_ = tx.Rollback()
-		}()
+	return dsn, nil
+}
 
-		err = d.applyMigrations(ctx, migrationsTable, tx, migrations)
-		if err != nil {
-			return err
-		}
+// uriPathReplacer escapes the characters that have a meaning in sqlite uri filenames
+var uriPathReplacer = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
 
-		return tx.Commit()
+// isRetryable returns true when the database is busy or locked
+func isRetryable(err error) bool {
+	var serr *sqlite.Error
+	if !errors.As(err, &serr) {
+		return false
 	}
 
-	return d.applyMigrations(ctx, migrationsTable, nil, migrations)
+	// extended result codes keep the primary result code in the lower 8 bits
+	switch serr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	default:
+		return false
+	}
 }
 
-func (d *driver) applyMigrations(ctx context.Context, migrationsTable string, tx *sql.Tx, migrations []simplemigrate.Migration) error {
-	insertQ := "INSERT INTO " + migrationsTable + " (version, fname, hash, applied_at) VALUES (?, ?, ?, ?)"
+// quoteIdentifier quotes an identifier with double quotes
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
 
-	for _, m := range migrations {
-		fmt.Printf("%s...", m.Fname)
+// DumpSchema returns the schema of the database
+// It is built from sqlite_master and the table_info and foreign_key_list pragmas
+func (d *driver) DumpSchema(ctx context.Context) (*simplemigrate.Schema, error) {
+	schema := simplemigrate.Schema{Dialect: d.Dialect()}
 
-		if err := d.applyOne(ctx, insertQ, tx, m); err != nil {
-			fmt.Printf("FAILED\n")
+	rows, err := d.DB().QueryContext(ctx, `
+		SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+		ORDER BY type, name
+	`)
+	if err != nil {
+		return nil, err
+	}
 
-			return err
+	defer rows.Close()
+
+	for rows.Next() {
+		var typ, name, tblName, ddl string
+
+		if err := rows.Scan(&typ, &name, &tblName, &ddl); err != nil {
+			return nil, err
 		}
 
-		fmt.Printf("OK\n")
+		switch typ {
+		case "table":
+			schema.Tables = append(schema.Tables, simplemigrate.Table{Name: name, DDL: ddl})
+		case "index":
+			schema.Indexes = append(schema.Indexes, simplemigrate.Index{Name: name, Table: tblName, DDL: ddl})
+		case "view":
+			schema.Views = append(schema.Views, simplemigrate.View{Name: name, DDL: ddl})
+		}
 	}
 
-	return nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range schema.Tables {
+		if err := d.inspectTable(ctx, &schema.Tables[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &schema, nil
 }
 
-func (d *driver) applyOne(ctx context.Context, insertQ string, tx *sql.Tx, m simplemigrate.Migration) error {
-	trans, rollback, commit, err := d.createTxIfNotExists(ctx, tx)
+// inspectTable reads the columns and the constraints of a table
+func (d *driver) inspectTable(ctx context.Context, table *simplemigrate.Table) error {
+	rows, err := d.DB().QueryContext(ctx,
+		`SELECT name, type, "notnull", COALESCE(dflt_value, ''), pk FROM pragma_table_info(?) ORDER BY cid`, table.Name)
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		_ = rollback()
-	}()
+	defer rows.Close()
+
+	var pk []string
+
+	for rows.Next() {
+		var (
+			c     simplemigrate.Column
+			pkIdx int
+		)
 
-	for _, query := range m.Statements {
-		_, err = trans.ExecContext(ctx, query)
-		if err != nil {
+		if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &c.Default, &pkIdx); err != nil {
 			return err
 		}
+
+		if pkIdx > 0 {
+			pk = append(pk, c.Name)
+		}
+
+		table.Columns = append(table.Columns, c)
 	}
 
-	_, err = trans.ExecContext(ctx, insertQ, m.Version, m.Fname, m.Hash, time.Now().UTC().Format(time.RFC3339Nano))
-	if err != nil {
+	if err := rows.Err(); err != nil {
 		return err
 	}
 
-	err = commit()
+	if len(pk) > 0 {
+		table.Constraints = append(table.Constraints, simplemigrate.Constraint{
+			Name:       table.Name + "_pkey",
+			Definition: "PRIMARY KEY (" + strings.Join(pk, ", ") + ")",
+		})
+	}
+
+	fkRows, err := d.DB().QueryContext(ctx,
+		`SELECT id, "table", "from", COALESCE("to", '') FROM pragma_foreign_key_list(?) ORDER BY id, seq`, table.Name)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	defer fkRows.Close()
+
+	type foreignKey struct {
+		ref      string
+		from, to []string
+	}
+
+	var fks []*foreignKey
+
+	lastID := -1
+
+	for fkRows.Next() {
+		var (
+			id            int
+			ref, from, to string
+		)
+
+		if err := fkRows.Scan(&id, &ref, &from, &to); err != nil {
+			return err
+		}
+
+		if id != lastID {
+			fks = append(fks, &foreignKey{ref: ref})
+			lastID = id
+		}
 
-//nolint:gocritic // TODO: refactor
-func (d *driver) createTxIfNotExists(
-	ctx context.Context,
-	tx *sql.Tx,
-) (*sql.Tx, func() error, func() error, error) {
-	if tx != nil {
-		return tx, func() error { return nil }, func() error { return nil }, nil
+		fk := fks[len(fks)-1]
+		fk.from = append(fk.from, from)
+		fk.to = append(fk.to, to)
 	}
 
-	tx, err := d.db.BeginTx(ctx, nil)
+	if err := fkRows.Err(); err != nil {
+		return err
+	}
+
+	for i, fk := range fks {
+		definition := "FOREIGN KEY (" + strings.Join(fk.from, ", ") + ") REFERENCES " + fk.ref
+
+		// the referenced columns are empty when the primary key is referenced implicitly
+		if to := strings.Join(fk.to, ", "); strings.Trim(to, ", ") != "" {
+			definition += " (" + to + ")"
+		}
+
+		table.Constraints = append(table.Constraints, simplemigrate.Constraint{
+			Name:       fmt.Sprintf("%s_fkey%d", table.Name, i+1),
+			Definition: definition,
+		})
+	}
+
+	return nil
+}
+
+// Scratch returns a driver for an empty in-memory database
+// The returned function closes it
+func (d *driver) Scratch(_ context.Context) (simplemigrate.DBDriver, func(context.Context) error, error) {
+	db, err := Connect(":memory:")
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
-	return tx, tx.Rollback, tx.Commit, nil
+	// every connection to :memory: opens a different database
+	db.SetMaxOpenConns(1)
+
+	return New(db), func(context.Context) error { return db.Close() }, nil
 }
@@ -0,0 +1,152 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gosom/simplemigrate"
+	"github.com/gosom/simplemigrate/drivertest"
+	"github.com/gosom/simplemigrate/sqlite"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	drivertest.Run(t, func(t *testing.T) simplemigrate.DBDriver {
+		t.Helper()
+
+		db, err := sqlite.Connect(filepath.Join(t.TempDir(), "test.db"))
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+
+		return sqlite.New(db)
+	})
+}
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	for _, scheme := range []string{"sqlite://", "sqlite3://", "file:"} {
+		driver, err := simplemigrate.Open(context.Background(), scheme+filepath.Join(t.TempDir(), "test.db"))
+		require.NoError(t, err, scheme)
+		require.Equal(t, "sqlite", driver.Dialect())
+		require.NoError(t, driver.Close(context.Background()))
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "sqlite:///var/data/app.db", want: "file:///var/data/app.db"},
+		{url: "sqlite3:///var/data/app.db", want: "file:///var/data/app.db"},
+		{url: "sqlite://./data/app.db", want: "file:./data/app.db"},
+		{url: "sqlite://app.db", want: "file:app.db"},
+		{url: "file:app.db", want: "file:app.db"},
+		{url: "file:///var/data/app.db", want: "file:///var/data/app.db"},
+		{url: "sqlite://:memory:", want: "file::memory:?cache=shared"},
+		{url: "file::memory:", want: "file::memory:?cache=shared"},
+		{
+			url:  "sqlite:///var/data/app.db?busy_timeout=5000&journal_mode=WAL&foreign_keys=on",
+			want: "file:///var/data/app.db?_pragma=busy_timeout%285000%29&_pragma=journal_mode%28WAL%29&_pragma=foreign_keys%28on%29",
+		},
+		{url: "sqlite://app.db?_pragma=busy_timeout(5000)", want: "file:app.db?_pragma=busy_timeout%285000%29"},
+	}
+
+	for _, tt := range tests {
+		got, err := sqlite.ParseURL(tt.url)
+		require.NoError(t, err, tt.url)
+		require.Equal(t, tt.want, got, tt.url)
+	}
+
+	for _, invalid := range []string{
+		"sqlite://",
+		"postgres://localhost/app",
+		"sqlite://app.db?jurnal_mode=WAL",
+		"sqlite://app.db?journal_mode=WAL);DROP",
+	} {
+		_, err := sqlite.ParseURL(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestParseURL_Pragmas(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dsn, err := sqlite.ParseURL("sqlite://" + filepath.Join(t.TempDir(), "test.db") +
+		"?busy_timeout=5000&journal_mode=WAL&foreign_keys=on")
+	require.NoError(t, err)
+
+	db, err := sqlite.Connect(dsn)
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	// the pragmas are set on every connection of the pool
+	conns := make([]*sql.Conn, 3)
+
+	for i := range conns {
+		conns[i], err = db.Conn(ctx)
+		require.NoError(t, err)
+
+		defer conns[i].Close()
+	}
+
+	for _, conn := range conns {
+		var (
+			busyTimeout, foreignKeys int
+			journalMode              string
+		)
+
+		require.NoError(t, conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout))
+		require.NoError(t, conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys))
+		require.NoError(t, conn.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode))
+
+		require.Equal(t, 5000, busyTimeout)
+		require.Equal(t, 1, foreignKeys)
+		require.Equal(t, "wal", journalMode)
+	}
+}
+
+func TestOpen_Memory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	dsn, err := sqlite.ParseURL("sqlite://:memory:")
+	require.NoError(t, err)
+
+	db, err := sqlite.Connect(dsn)
+	require.NoError(t, err)
+
+	defer db.Close()
+
+	first, err := db.Conn(ctx)
+	require.NoError(t, err)
+
+	defer first.Close()
+
+	second, err := db.Conn(ctx)
+	require.NoError(t, err)
+
+	defer second.Close()
+
+	// the connections share the database
+	_, err = first.ExecContext(ctx, "CREATE TABLE shared_memory_test (id INTEGER)")
+	require.NoError(t, err)
+
+	_, err = second.ExecContext(ctx, "INSERT INTO shared_memory_test (id) VALUES (1)")
+	require.NoError(t, err)
+}
@@ -0,0 +1,171 @@
+package simplemigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrBaseline is returned when a database cannot be baselined
+var ErrBaseline = errors.New("cannot baseline")
+
+// MigrationStatus describes a migration file and its state in the database
+type MigrationStatus struct {
+	// Migration is the local file, with the history of the database if it is applied
+	Migration
+	// Applied is true if the migration is recorded in the migrations table
+	Applied bool
+	// Modified is true if the file changed after it was applied
+	Modified bool
+	// Missing is true if the migration is applied but its file does not exist
+	Missing bool
+}
+
+// Pending returns true if the migration runs with the next Migrate
+func (s MigrationStatus) Pending() bool {
+	return !s.Applied
+}
+
+// Status returns the state of every local and applied migration sorted by version
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	localMigrations, err := m.readMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	appliedMigrations, err := m.History(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]Migration, len(appliedMigrations))
+	for _, migration := range appliedMigrations {
+		applied[migration.Version] = migration
+	}
+
+	ans := make([]MigrationStatus, 0, len(localMigrations))
+
+	for _, local := range localMigrations {
+		status := MigrationStatus{Migration: local}
+
+		if row, ok := applied[local.Version]; ok {
+			status.Migration = withHistory(local, row)
+			status.Applied = true
+			status.Modified = row.Hash != local.Hash
+
+			delete(applied, local.Version)
+		}
+
+		ans = append(ans, status)
+	}
+
+	for _, row := range appliedMigrations {
+		if _, ok := applied[row.Version]; ok {
+			ans = append(ans, MigrationStatus{Migration: row, Applied: true, Missing: true})
+		}
+	}
+
+	return ans, nil
+}
+
+// History returns the applied migrations sorted by version
+// The migrations table is created (or upgraded) if needed while holding the
+// migration lock, so that it does not race with a concurrent Migrate
+func (m *Migrator) History(ctx context.Context) (_ []Migration, err error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if uerr := unlock(ctx); uerr != nil && err == nil {
+			err = uerr
+		}
+	}()
+
+	return m.history(ctx)
+}
+
+// history returns the applied migrations sorted by version
+// The caller must hold the migration lock
+func (m *Migrator) history(ctx context.Context) ([]Migration, error) {
+	if err := m.driver.CreateMigrationsTable(ctx, m.migrationsTable); err != nil {
+		return nil, err
+	}
+
+	return m.driver.SelectMigrations(ctx, m.migrationsTable)
+}
+
+// Validate reads the migration files, checks their versions and runs the
+// query validators on all of them without touching the database
+// It returns the migrations that were validated
+func (m *Migrator) Validate(ctx context.Context) ([]Migration, error) {
+	migrations, err := m.readMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.validateAll(ctx, migrations); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// Baseline records the migrations up to version as applied without running them
+// It is used to adopt a database whose schema already matches those migrations,
+// so it requires that no migrations are applied
+func (m *Migrator) Baseline(ctx context.Context, version int) (err error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if uerr := unlock(ctx); uerr != nil && err == nil {
+			err = uerr
+		}
+	}()
+
+	localMigrations, err := m.readMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version < 1 || version > len(localMigrations) {
+		return fmt.Errorf("%w: version %d does not exist, the latest is %d", ErrBaseline, version, len(localMigrations))
+	}
+
+	appliedMigrations, err := m.history(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(appliedMigrations) > 0 {
+		return fmt.Errorf("%w: %d migrations are already applied", ErrBaseline, len(appliedMigrations))
+	}
+
+	baseline := make([]Migration, version)
+	for i, migration := range localMigrations[:version] {
+		// without statements only the history row is written
+		migration.Statements = nil
+		baseline[i] = migration
+	}
+
+	m.stampMigrations(baseline)
+
+	return m.driver.ApplyMigrations(ctx, m.migrationsTable, true, baseline)
+}
+
+// withHistory returns local with the history fields of the applied row
+func withHistory(local, row Migration) Migration {
+	local.AppliedAt = row.AppliedAt
+	local.ExecutionTime = row.ExecutionTime
+	local.AppliedBy = row.AppliedBy
+	local.Hostname = row.Hostname
+	local.AppVersion = row.AppVersion
+	local.ToolVersion = row.ToolVersion
+	local.Dirty = row.Dirty
+
+	return local
+}
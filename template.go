@@ -0,0 +1,41 @@
+package simplemigrate
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// WithTemplateVars is an option to render the migration files as Go
+// templates with vars, e.g. "CREATE SCHEMA {{ .schema }}"
+// The hash of a migration is computed before rendering, so a file has
+// the same hash whatever the values of the vars are
+// Files are not rendered if vars is empty
+func WithTemplateVars(vars map[string]string) Option {
+	return func(m *Migrator) error {
+		m.templateVars = vars
+
+		return nil
+	}
+}
+
+// render executes the migration file data as a template with the template vars
+// Referencing a var that is not set is an error
+func (m *Migrator) render(fname string, data []byte) ([]byte, error) {
+	if len(m.templateVars) == 0 {
+		return data, nil
+	}
+
+	tmpl, err := template.New(fname).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMigrationFile, err.Error())
+	}
+
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, m.templateVars); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMigrationFile, err.Error())
+	}
+
+	return buf.Bytes(), nil
+}
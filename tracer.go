@@ -0,0 +1,61 @@
+package simplemigrate
+
+import (
+	"context"
+)
+
+// Attribute keys used in the spans created by the Migrator
+const (
+	AttrDialect        = "db.system"
+	AttrMigrationTable = "simplemigrate.table"
+	AttrVersion        = "simplemigrate.version"
+	AttrFname          = "simplemigrate.file"
+	AttrStatementIndex = "simplemigrate.statement_index"
+	AttrRowsAffected   = "simplemigrate.rows_affected"
+	AttrCount          = "simplemigrate.count"
+)
+
+// Attribute is a key value pair attached to a span
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Tracer represents a tracer that creates spans
+// The oteltracer package contains an adapter for OpenTelemetry
+type Tracer interface {
+	// Start creates a span and returns a context containing it
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span represents a single traced operation
+type Span interface {
+	// SetAttributes sets attributes on the span
+	SetAttributes(attrs ...Attribute)
+	// RecordError records an error on the span
+	RecordError(err error)
+	// End ends the span
+	End()
+}
+
+// endSpan records err on the span (if any) and ends it
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	span.End()
+}
+
+// nopTracer is the Tracer used when WithTracer is not set
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Attribute) {}
+func (nopSpan) RecordError(error)          {}
+func (nopSpan) End()                       {}